@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/shared"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// UsageError is returned for invalid command lines, main exits with status 2
+// after printing it.
+type UsageError struct {
+	msg string
+}
+
+func (e *UsageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &UsageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// command is a kv subcommand.
+type command struct {
+	name    string
+	args    string // positional arguments shown in help
+	summary string
+	minArgs int
+	maxArgs int                            // -1 means unlimited
+	plugin  bool                           // runs against a connected plugin
+	serves  bool                           // runs until stopped, not traced as one span
+	flags   func(c *cli, fs *flag.FlagSet) // registers command flags, optional
+	run     func(c *cli, args []string) error
+}
+
+func (cmd *command) usage(w io.Writer, fs *flag.FlagSet) {
+	args := cmd.args
+	if cmd.flags != nil {
+		args = strings.TrimSpace("[command flags] " + args)
+	}
+
+	fmt.Fprintf(w, "Usage: kv [flags] %s %s\n\n%s\n", cmd.name, args, cmd.summary)
+
+	if cmd.flags != nil {
+		fmt.Fprintf(w, "\nCommand flags:\n")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+// cli holds the state of one kv invocation.
+type cli struct {
+	ctx         context.Context // carries the span of the command
+	stopTracing func(ctx context.Context) error
+	settings    *Settings
+	loggers     *Loggers
+	output      *Output
+	stores      []*StoreConfig
+	lock        *PluginLock
+	host        *Host
+	kv          *Supervisor
+	health      *HealthMonitor
+
+	export exportOptions
+	imp    importOptions
+}
+
+func commands() []*command {
+	return []*command{
+		{name: "get", args: "<key>", summary: "Print the value of a key to stdout.", minArgs: 1, maxArgs: 1, plugin: true, run: (*cli).get},
+		{name: "put", args: "<key> <value>", summary: "Set the value of a key, \"-\" reads the value from stdin.", minArgs: 2, maxArgs: 2, plugin: true, run: (*cli).put},
+		{name: "delete", args: "<key>", summary: "Delete a key.", minArgs: 1, maxArgs: 1, plugin: true, run: (*cli).delete},
+		{name: "list", args: "[prefix]", summary: "List keys, optionally only those starting with prefix.", maxArgs: 1, plugin: true, run: (*cli).list},
+		{name: "capabilities", summary: "Print the features supported by the plugin.", plugin: true, run: (*cli).capabilities},
+		{name: "status", summary: "Check the plugin health, fails unless it is ready.", plugin: true, run: (*cli).status},
+		{name: "log-level", args: "<level>", summary: "Change the log level of the daemon plugin; serve reloads log_level on SIGHUP instead.", minArgs: 1, maxArgs: 1, plugin: true, run: (*cli).logLevel},
+		{name: "export", args: "[file]", summary: "Write keys and values to file or stdout, as JSON lines or a binary archive.", maxArgs: 1, plugin: true, flags: exportFlags, run: (*cli).exportStore},
+		{name: "import", args: "[file]", summary: "Read keys and values written by export from file or stdin.", maxArgs: 1, plugin: true, flags: importFlags, run: (*cli).importStore},
+		{name: "snapshot", args: "<file>", summary: "Write a consistent snapshot of the store to file, with a checksum.", minArgs: 1, maxArgs: 1, plugin: true, run: (*cli).snapshot},
+		{name: "restore", args: "<file>", summary: "Verify a snapshot file and atomically replace the store with it.", minArgs: 1, maxArgs: 1, plugin: true, run: (*cli).restore},
+		{name: "shell", summary: "Run get, put, delete, list and watch commands interactively.", plugin: true, serves: true, run: (*cli).shell},
+		{name: "serve", args: "[address...]", summary: "Serve the store over gRPC and REST (tcp://, unix://, http:// addresses).", maxArgs: -1, plugin: true, serves: true, run: (*cli).serve},
+		{name: "daemon", summary: "Run the plugin in the background for other invocations to reattach to.", serves: true, run: (*cli).daemon},
+		{name: "plugins", args: "verify", summary: "Verify plugin binaries against the lockfile.", minArgs: 1, maxArgs: 1, run: (*cli).plugins},
+		{name: "help", args: "[command]", summary: "Show help for kv or one of its commands.", maxArgs: 1},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+func printUsage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: kv [flags] <command> [args]\n\nCommands:\n")
+
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-26s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.summary)
+	}
+
+	fmt.Fprintf(w, "\nFlags:\n")
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+
+	fmt.Fprintf(w, "\nSettings are read from flags, KV_* environment variables and the config\n"+
+		"file (%s or %s), in that order of precedence.\n", DefaultConfigFile, EnvConfigFile)
+}
+
+func run() error {
+	flags := flag.NewFlagSet("kv", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	configPath := flags.String("config", "", "config file `path` (default "+DefaultConfigFile+" if it exists)")
+	storeName := flags.String("store", "", "`name` of the one store this invocation uses, serve and shell included (default the first configured one)")
+	settingFlags := map[string]*string{
+		EnvPluginPath: flags.String("plugin", "", "plugin binary `path`"),
+
+		EnvOutputFormat: flags.String("output", "", "command result `format` on stdout (raw, json, hex, base64)"),
+		EnvLogLevel:     flags.String("log-level", "", "host log `level` (trace, debug, info, warn, error)"),
+		EnvLogFormat:    flags.String("log-format", "", "host log `format` (console, json, logfmt)"),
+		EnvLogOutput:    flags.String("log-output", "", "host log `output` (stderr, stdout, syslog or a file path)"),
+
+		EnvLogPluginFormat: flags.String("plugin-log-format", "", "plugin log `format`, default -log-format"),
+		EnvLogPluginOutput: flags.String("plugin-log-output", "", "plugin log `output`, default -log-output"),
+	}
+
+	err := flags.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage(os.Stdout, flags)
+		return nil
+	}
+
+	if err != nil {
+		printUsage(os.Stderr, flags)
+		return usageErrorf("%v", err)
+	}
+
+	if flags.NArg() == 0 {
+		printUsage(os.Stderr, flags)
+		return usageErrorf("no command given")
+	}
+
+	cmd := findCommand(flags.Arg(0))
+	if cmd == nil {
+		printUsage(os.Stderr, flags)
+		return usageErrorf("unknown command %q", flags.Arg(0))
+	}
+
+	c := &cli{}
+
+	cmdFlags := flag.NewFlagSet("kv "+cmd.name, flag.ContinueOnError)
+	cmdFlags.SetOutput(io.Discard)
+
+	if cmd.flags != nil {
+		cmd.flags(c, cmdFlags)
+	}
+
+	err = cmdFlags.Parse(flags.Args()[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		cmd.usage(os.Stdout, cmdFlags)
+		return nil
+	}
+
+	args := cmdFlags.Args()
+
+	if err == nil && len(args) < cmd.minArgs {
+		err = fmt.Errorf("%s: missing arguments", cmd.name)
+	}
+
+	if err == nil && cmd.maxArgs >= 0 && len(args) > cmd.maxArgs {
+		err = fmt.Errorf("%s: too many arguments", cmd.name)
+	}
+
+	if err != nil {
+		cmd.usage(os.Stderr, cmdFlags)
+		return usageErrorf("%v", err)
+	}
+
+	if cmd.name == "help" {
+		return help(flags, args)
+	}
+
+	overrides := map[string]string{}
+	for name, value := range settingFlags {
+		overrides[name] = *value
+	}
+
+	settings, err := LoadSettings(*configPath, overrides)
+	if err != nil {
+		return err
+	}
+
+	outputFormat, err := ParseOutputFormat(settings.Get(EnvOutputFormat))
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	c.settings = settings
+	c.output = NewOutput(os.Stdout, outputFormat)
+
+	err = c.setup(*storeName)
+	if err != nil {
+		return err
+	}
+	defer c.shutdownTracing()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go c.host.ReloadConfigOnSignal(ctx)
+
+	if cmd.plugin {
+		err = c.connect(ctx)
+		if err != nil {
+			return err
+		}
+		defer c.kv.Stop()
+	}
+
+	c.ctx = ctx
+
+	if cmd.serves {
+		return cmd.run(c, args)
+	}
+
+	var span trace.Span
+	c.ctx, span = shared.Tracer().Start(ctx, "kv "+cmd.name)
+	defer span.End()
+
+	// the plugin calls of a command share its request ID
+	c.ctx, _ = shared.WithRequestID(c.ctx)
+
+	err = cmd.run(c, args)
+	if err != nil {
+		zerolog.Ctx(c.ctx).Error().Err(err).Str("command", cmd.name).Msg("Command failed.")
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+
+	return err
+}
+
+// setup configures logging and the host of the selected store.
+func (c *cli) setup(storeName string) error {
+	loggers, err := configureLoggers(c.settings)
+	if err != nil {
+		return err
+	}
+
+	c.loggers = loggers
+	shared.MainLogHelper = NewLogHelper(loggers.Plugin)
+	zlog.Logger = loggers.Main.With().Str("app", MainProcessLogLabel).Logger()
+
+	// contexts without a request ID log with the main logger
+	zerolog.DefaultContextLogger = &zlog.Logger
+
+	zlog.Info().Msg("Started main process.")
+
+	c.stores, err = StoresFromEnv(c.settings.Get)
+	if err != nil {
+		return err
+	}
+
+	c.lock, err = LoadPluginLockFromEnv()
+	if err != nil {
+		return err
+	}
+
+	store, err := FindStore(c.stores, storeName)
+	if err != nil {
+		return err
+	}
+
+	tlsFiles, err := shared.TLSFilesFromEnv()
+	if err != nil {
+		return err
+	}
+
+	tracing, err := shared.TracingConfigFromEnv(c.settings.Get)
+	if err != nil {
+		return err
+	}
+
+	c.stopTracing, err = shared.ConfigureTracing(tracing, "kv")
+	if err != nil {
+		return err
+	}
+
+	c.host = NewHost(store, c.settings, c.lock, tlsFiles, tracing, loggers)
+
+	return nil
+}
+
+// shutdownTracing exports the remaining spans of the invocation.
+func (c *cli) shutdownTracing() {
+	if c.stopTracing == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := c.stopTracing(ctx)
+	if err != nil {
+		zlog.Warn().Err(err).Msg("Failed to export spans.")
+	}
+}
+
+// connect reattaches to the daemon plugin or launches our own, the
+// supervisor restarts a launched plugin if its process dies.
+func (c *cli) connect(ctx context.Context) error {
+	healthOpts, err := c.settings.HealthMonitorOptions()
+	if err != nil {
+		return err
+	}
+
+	c.kv, err = c.host.Connect()
+	if err != nil {
+		return err
+	}
+
+	c.health = NewHealthMonitor(c.kv, healthOpts)
+	go c.health.Run(ctx)
+
+	return nil
+}
+
+func help(flags *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		printUsage(os.Stdout, flags)
+		return nil
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		return usageErrorf("unknown command %q", args[0])
+	}
+
+	cmdFlags := flag.NewFlagSet("kv "+cmd.name, flag.ContinueOnError)
+	if cmd.flags != nil {
+		cmd.flags(&cli{}, cmdFlags)
+	}
+
+	cmd.usage(os.Stdout, cmdFlags)
+
+	return nil
+}
+
+func (c *cli) get(args []string) error {
+	value, contentType, err := c.kv.GetContext(c.ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.output.Value(args[0], value, contentType)
+}
+
+func (c *cli) put(args []string) error {
+	value := []byte(args[1])
+
+	if args[1] == "-" {
+		var err error
+
+		value, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.kv.PutContext(c.ctx, args[0], value, "")
+}
+
+func (c *cli) delete(args []string) error {
+	err := c.kv.DeleteContext(c.ctx, args[0])
+	if errors.Is(err, shared.ErrUnsupported) {
+		return fmt.Errorf("plugin does not support delete: %w", err)
+	}
+
+	return err
+}
+
+func (c *cli) list(args []string) error {
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+
+	keys, err := c.kv.ListContext(c.ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	return c.output.Keys(keys)
+}
+
+func (c *cli) capabilities(args []string) error {
+	caps, err := c.kv.Capabilities()
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		Features     []string `json:"features"`
+		MaxValueSize uint64   `json:"max_value_size"`
+		Durability   string   `json:"durability"`
+	}{caps.Features(), caps.MaxValueSize, caps.Durability.String()}
+
+	return c.output.Result(result, caps.String())
+}
+
+func (c *cli) status(args []string) error {
+	report := c.health.Check()
+
+	text := report.State.String()
+	if len(report.Reasons) > 0 {
+		text += ": " + strings.Join(report.Reasons, ", ")
+	}
+
+	result := struct {
+		State   string   `json:"state"`
+		Reasons []string `json:"reasons"`
+	}{report.State.String(), append([]string{}, report.Reasons...)}
+
+	err := c.output.Result(result, text)
+	if err != nil {
+		return err
+	}
+
+	if report.State != HealthReady {
+		return fmt.Errorf("plugin is %v", report.State)
+	}
+
+	return nil
+}
+
+// logLevel changes the log level of the daemon plugin. The plugin of any
+// other invocation exits with it, so there is nothing to change.
+func (c *cli) logLevel(args []string) error {
+	level, err := shared.ParseLogLevel(args[0])
+	if err != nil {
+		return err
+	}
+
+	if !c.kv.Attached() {
+		return errors.New("no daemon is running, log-level only applies to the plugin of `kv daemon`, other invocations reload log_level from the config on SIGHUP")
+	}
+
+	err = c.kv.SetLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	// the daemon applies the level again if it restarts the plugin
+	statePath := DaemonStateFile(c.host.store)
+
+	state, err := LoadDaemonState(statePath)
+	if err == nil && state != nil {
+		state.LogLevel = level.String()
+		err = state.Save(statePath)
+	}
+
+	if err != nil {
+		zlog.Warn().Err(err).Str("state_file", statePath).Msg("Failed to record plugin log level in daemon state.")
+	}
+
+	zlog.Info().Stringer("log_level", level).Msg("Plugin log level changed.")
+
+	return nil
+}
+
+func (c *cli) serve(args []string) error {
+	if len(args) == 0 {
+		var err error
+
+		args, err = parseList(EnvListen, c.settings.Get(EnvListen), strings.Fields)
+		if err != nil {
+			return err
+		}
+	}
+
+	tlsConfig, err := serveTLSConfig(c.settings.Get)
+	if err != nil {
+		return err
+	}
+
+	return runServe(c.kv, c.health, args, tlsConfig)
+}
+
+func (c *cli) daemon(args []string) error {
+	return runDaemon(c.host)
+}
+
+func (c *cli) plugins(args []string) error {
+	if args[0] != "verify" {
+		return usageErrorf("unknown plugins command %q, use 'plugins verify'", args[0])
+	}
+
+	return verifyPlugins(c.stores, c.lock)
+}
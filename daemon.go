@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hashicorp/go-plugin"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+// runDaemon starts the plugin and keeps it running until SIGINT or SIGTERM,
+// publishing its reattach info in the state file so that other CLI
+// invocations can reuse it instead of spawning their own plugin.
+func runDaemon(host *Host) error {
+	statePath := DaemonStateFile(host.store)
+
+	state, err := LoadDaemonState(statePath)
+	if err != nil {
+		return err
+	}
+
+	if state != nil && !state.Stale() {
+		return errors.New("daemon is already running: " + state.String())
+	}
+
+	// the log level of a previous daemon does not apply to this one
+	if state != nil {
+		err = removeDaemonState(statePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// AutoMTLS certificates are private to this process, so reattaching
+	// clients need a CA of their own
+	if host.tls == nil {
+		tlsDir := statePath + ".tls"
+
+		host.tls, err = shared.GenerateTLSFiles(tlsDir)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tlsDir)
+	}
+
+	opts, err := host.settings.SupervisorOptions()
+	if err != nil {
+		return err
+	}
+
+	// set by `kv log-level` in the state file
+	opts.LogLevel = func() (shared.LogLevel, bool) {
+		state, err := LoadDaemonState(statePath)
+		if err != nil || state == nil || state.LogLevel == "" {
+			return 0, false
+		}
+
+		level, err := shared.ParseLogLevel(state.LogLevel)
+
+		return level, err == nil
+	}
+
+	opts.OnLaunch = func(client *plugin.Client) {
+		previous, _ := LoadDaemonState(statePath)
+
+		state, err := NewDaemonState(client, host.spec.Path, host.tls)
+		if err == nil {
+			if previous != nil {
+				state.LogLevel = previous.LogLevel
+			}
+
+			err = state.Save(statePath)
+		}
+
+		if err != nil {
+			zlog.Error().Err(err).Str("state_file", statePath).Msg("Failed to write daemon state.")
+			return
+		}
+
+		zlog.Info().Str("state_file", statePath).Stringer("state", state).Msg("Daemon state written.")
+	}
+
+	kv, err := host.Launch(opts)
+	if err != nil {
+		return err
+	}
+	defer kv.Stop()
+	defer removeDaemonState(statePath)
+
+	sig := <-shutdownSignals()
+	zlog.Info().Stringer("signal", sig).Msg("Stopping daemon.")
+
+	return nil
+}
+
+func removeDaemonState(path string) error {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// shutdownSignals returns a channel receiving SIGINT and SIGTERM.
+func shutdownSignals() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	return signals
+}
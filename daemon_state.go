@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-plugin"
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+const (
+	EnvDaemonStateFile     = "KV_STATE_FILE"
+	DefaultDaemonStateFile = ".kv-daemon.json"
+)
+
+// DaemonState is written by `kv daemon` and holds everything needed for
+// other CLI invocations to reattach to the running plugin.
+type DaemonState struct {
+	DaemonPid       int       `json:"daemon_pid"`
+	PluginPid       int       `json:"plugin_pid"`
+	PluginPath      string    `json:"plugin_path"`
+	Protocol        string    `json:"protocol"`
+	ProtocolVersion int       `json:"protocol_version"`
+	Network         string    `json:"network"`
+	Address         string    `json:"address"`
+	Updated         time.Time `json:"updated"`
+
+	// TLS holds the mTLS files clients use to talk to the plugin.
+	TLS *shared.TLSFiles `json:"tls,omitempty"`
+
+	// LogLevel is the plugin log level set by `kv log-level`, the daemon
+	// applies it again to restarted plugins.
+	LogLevel string `json:"log_level,omitempty"`
+}
+
+// DaemonStateFile returns the state file path of a store, stores other than
+// the default one get their name inserted before the extension.
+func DaemonStateFile(store string) string {
+	path := os.Getenv(EnvDaemonStateFile)
+	if path == "" {
+		path = DefaultDaemonStateFile
+	}
+
+	if store == DefaultStoreName {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+
+	return strings.TrimSuffix(path, ext) + "." + store + ext
+}
+
+// NewDaemonState captures the reattach info of a started plugin client.
+func NewDaemonState(client *plugin.Client, pluginPath string, tls *shared.TLSFiles) (*DaemonState, error) {
+	reattach := client.ReattachConfig()
+	if reattach == nil {
+		return nil, fmt.Errorf("plugin client is not started")
+	}
+
+	state := &DaemonState{
+		DaemonPid:       os.Getpid(),
+		PluginPid:       reattach.Pid,
+		PluginPath:      pluginPath,
+		Protocol:        string(reattach.Protocol),
+		ProtocolVersion: client.NegotiatedVersion(),
+		Network:         reattach.Addr.Network(),
+		Address:         reattach.Addr.String(),
+		Updated:         time.Now(),
+		TLS:             tls,
+	}
+
+	return state, nil
+}
+
+// LoadDaemonState reads the state file, it returns nil if there is none.
+func LoadDaemonState(path string) (*DaemonState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	state := &DaemonState{}
+
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, fmt.Errorf("invalid daemon state file %s: %v", path, err)
+	}
+
+	return state, nil
+}
+
+// Save atomically writes the state file.
+func (s *DaemonState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Stale reports whether the daemon or its plugin process is gone.
+func (s *DaemonState) Stale() bool {
+	return !processAlive(s.DaemonPid) || !processAlive(s.PluginPid)
+}
+
+// ReattachConfig returns the go-plugin config to reattach to the plugin.
+func (s *DaemonState) ReattachConfig() (*plugin.ReattachConfig, error) {
+	var addr net.Addr
+	var err error
+
+	switch s.Network {
+	case "unix":
+		addr, err = net.ResolveUnixAddr(s.Network, s.Address)
+	case "tcp":
+		addr, err = net.ResolveTCPAddr(s.Network, s.Address)
+	default:
+		err = fmt.Errorf("unknown network %q", s.Network)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	reattach := &plugin.ReattachConfig{
+		Protocol:        plugin.Protocol(s.Protocol),
+		ProtocolVersion: s.ProtocolVersion,
+		Addr:            addr,
+		Pid:             s.PluginPid,
+	}
+
+	return reattach, nil
+}
+
+func (s *DaemonState) String() string {
+	return fmt.Sprintf("daemon pid %d, plugin pid %d", s.DaemonPid, s.PluginPid)
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return process.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+// ExportFormat is the file format written by export, import detects it.
+type ExportFormat string
+
+const (
+	ExportJSONLines ExportFormat = "jsonl"   // one JSON record per line
+	ExportArchive   ExportFormat = "archive" // length prefixed binary records
+)
+
+func ParseExportFormat(str string) (ExportFormat, error) {
+	switch format := ExportFormat(str); format {
+	case ExportJSONLines, ExportArchive:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid export format %q, use jsonl or archive", str)
+	}
+}
+
+// archiveMagic starts a binary archive. Records follow, each is the key,
+// content type and value, every one of them prefixed with its length as an
+// uvarint. An empty key ends the records and is followed by the trailer: the
+// record count as an uvarint and the SHA-256 of the records and end marker,
+// so that an archive cut anywhere is rejected.
+var archiveMagic = []byte("KVARCH\x00\x01")
+
+// maxArchiveField bounds field lengths read from archives, so that a corrupt
+// length doesn't allocate gigabytes.
+const maxArchiveField = 1 << 30
+
+// ConflictPolicy decides what import does with keys that already exist.
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+)
+
+func ParseConflictPolicy(str string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(str); policy {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy %q, use skip, overwrite or fail", str)
+	}
+}
+
+// Record is an exported key.
+type Record struct {
+	Key         string `json:"key"`
+	Value       []byte `json:"value"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// RecordWriter writes records in an export format. Close ends the file, it
+// does not close the underlying writer.
+type RecordWriter interface {
+	Write(rec *Record) error
+	Close() error
+}
+
+func NewRecordWriter(w io.Writer, format ExportFormat) (RecordWriter, error) {
+	bw := bufio.NewWriter(w)
+
+	if format == ExportArchive {
+		_, err := bw.Write(archiveMagic)
+		if err != nil {
+			return nil, err
+		}
+
+		return &archiveWriter{w: bw, hash: sha256.New()}, nil
+	}
+
+	return &jsonLinesWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+}
+
+type jsonLinesWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonLinesWriter) Write(rec *Record) error {
+	return w.enc.Encode(rec)
+}
+
+func (w *jsonLinesWriter) Close() error {
+	return w.w.Flush()
+}
+
+type archiveWriter struct {
+	w     *bufio.Writer
+	hash  hash.Hash
+	count uint64
+}
+
+func (w *archiveWriter) Write(rec *Record) error {
+	if rec.Key == "" {
+		return errors.New("archive records must have a key")
+	}
+
+	// the checksum covers the records as written
+	out := io.MultiWriter(w.w, w.hash)
+
+	for _, field := range [][]byte{[]byte(rec.Key), []byte(rec.ContentType), rec.Value} {
+		_, err := out.Write(binary.AppendUvarint(nil, uint64(len(field))))
+		if err != nil {
+			return err
+		}
+
+		_, err = out.Write(field)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.count++
+
+	return nil
+}
+
+// Close writes the end marker and the trailer.
+func (w *archiveWriter) Close() error {
+	_, err := io.MultiWriter(w.w, w.hash).Write(binary.AppendUvarint(nil, 0))
+	if err != nil {
+		return err
+	}
+
+	trailer := binary.AppendUvarint(nil, w.count)
+	trailer = w.hash.Sum(trailer)
+
+	_, err = w.w.Write(trailer)
+	if err != nil {
+		return err
+	}
+
+	return w.w.Flush()
+}
+
+// RecordReader reads records, Read returns io.EOF after the last one.
+type RecordReader interface {
+	Read() (*Record, error)
+}
+
+// NewRecordReader detects the format of r from its first bytes.
+func NewRecordReader(r io.Reader) (RecordReader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(archiveMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if bytes.Equal(magic, archiveMagic) {
+		_, err = br.Discard(len(archiveMagic))
+		if err != nil {
+			return nil, err
+		}
+
+		return &archiveReader{r: br, hash: sha256.New()}, nil
+	}
+
+	return &jsonLinesReader{dec: json.NewDecoder(br)}, nil
+}
+
+type jsonLinesReader struct {
+	dec  *json.Decoder
+	line int
+}
+
+func (r *jsonLinesReader) Read() (*Record, error) {
+	rec := &Record{}
+
+	err := r.dec.Decode(rec)
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+
+	r.line++
+
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %w", r.line, err)
+	}
+
+	if rec.Key == "" {
+		return nil, fmt.Errorf("record %d: missing key", r.line)
+	}
+
+	return rec, nil
+}
+
+type archiveReader struct {
+	r      *bufio.Reader
+	hash   hash.Hash
+	record int
+	done   bool
+}
+
+func (r *archiveReader) Read() (*Record, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	key, err := r.field()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("archive is truncated after record %d: %w", r.record, io.ErrUnexpectedEOF)
+	}
+
+	if err == nil && len(key) == 0 {
+		err = r.readTrailer()
+		if err != nil {
+			return nil, fmt.Errorf("archive trailer: %w", err)
+		}
+
+		r.done = true
+
+		return nil, io.EOF
+	}
+
+	r.record++
+
+	var contentType, value []byte
+
+	if err == nil {
+		contentType, err = r.field()
+	}
+
+	if err == nil {
+		value, err = r.field()
+	}
+
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("archive record %d: %w", r.record, err)
+	}
+
+	return &Record{Key: string(key), Value: value, ContentType: string(contentType)}, nil
+}
+
+// readTrailer checks the record count and checksum following the end
+// marker, and that nothing follows them.
+func (r *archiveReader) readTrailer() error {
+	sum := r.hash.Sum(nil)
+
+	count, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	expected := make([]byte, sha256.Size)
+
+	_, err = io.ReadFull(r.r, expected)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	if count != uint64(r.record) {
+		return fmt.Errorf("archive has %d records, trailer expects %d", r.record, count)
+	}
+
+	if !bytes.Equal(sum, expected) {
+		return errors.New("archive checksum mismatch, the archive is corrupt")
+	}
+
+	_, err = r.r.ReadByte()
+	if !errors.Is(err, io.EOF) {
+		return errors.New("unexpected data after archive trailer")
+	}
+
+	return nil
+}
+
+// field reads a record field, the end marker being an empty key field.
+func (r *archiveReader) field() ([]byte, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > maxArchiveField {
+		return nil, fmt.Errorf("invalid field length %d", size)
+	}
+
+	field := make([]byte, size)
+
+	_, err = io.ReadFull(r.r, field)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	r.hash.Write(binary.AppendUvarint(nil, size))
+	r.hash.Write(field)
+
+	return field, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// ExportStore is the part of a store used by export and import, so that
+// any plugin can be exported and another one imported into.
+type ExportStore interface {
+	List(prefix string) ([]string, error)
+	GetWithContentType(key string) ([]byte, string, error)
+	PutWithContentType(key string, value []byte, contentType string) error
+}
+
+// Export writes the keys starting with prefix to w and returns their count.
+func Export(store ExportStore, w RecordWriter, prefix string) (int, error) {
+	keys, err := store.List(prefix)
+	if errors.Is(err, shared.ErrUnsupported) {
+		return 0, fmt.Errorf("plugin cannot list keys to export: %w", err)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, key := range keys {
+		value, contentType, err := store.GetWithContentType(key)
+		if errors.Is(err, shared.ErrNotFound) {
+			continue // deleted since listed
+		}
+
+		if err != nil {
+			return count, fmt.Errorf("export %q: %w", key, err)
+		}
+
+		err = w.Write(&Record{Key: key, Value: value, ContentType: contentType})
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, w.Close()
+}
+
+// ImportOptions controls Import.
+type ImportOptions struct {
+	Prefix   string // only records with keys starting with it are imported
+	Conflict ConflictPolicy
+	DryRun   bool // check records and conflicts without writing
+}
+
+// ImportStats counts what Import did, or would have done on a dry run.
+type ImportStats struct {
+	Imported    int `json:"imported"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+}
+
+// Import writes the records of r to store. It stops at the first error, so
+// records before it stay imported.
+func Import(store ExportStore, r RecordReader, opts ImportOptions) (ImportStats, error) {
+	stats := ImportStats{}
+
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return stats, nil
+		}
+
+		if err != nil {
+			return stats, err
+		}
+
+		if !strings.HasPrefix(rec.Key, opts.Prefix) {
+			continue
+		}
+
+		_, _, err = store.GetWithContentType(rec.Key)
+		exists := err == nil
+
+		if err != nil && !errors.Is(err, shared.ErrNotFound) {
+			return stats, fmt.Errorf("import %q: %w", rec.Key, err)
+		}
+
+		if exists {
+			switch opts.Conflict {
+			case ConflictSkip:
+				zlog.Debug().Str("key", rec.Key).Msg("Skipped existing key.")
+				stats.Skipped++
+				continue
+			case ConflictFail:
+				return stats, fmt.Errorf("import %q: key already exists", rec.Key)
+			}
+		}
+
+		if opts.DryRun {
+			zlog.Info().Str("key", rec.Key).Bool("exists", exists).Msg("Would import key.")
+		} else {
+			err = store.PutWithContentType(rec.Key, rec.Value, rec.ContentType)
+			if err != nil {
+				return stats, fmt.Errorf("import %q: %w", rec.Key, err)
+			}
+		}
+
+		if exists {
+			stats.Overwritten++
+		} else {
+			stats.Imported++
+		}
+	}
+}
+
+type exportOptions struct {
+	format string
+	prefix string
+}
+
+type importOptions struct {
+	prefix   string
+	conflict string
+	dryRun   bool
+}
+
+func exportFlags(c *cli, fs *flag.FlagSet) {
+	fs.StringVar(&c.export.format, "format", string(ExportJSONLines), "file `format` (jsonl, archive)")
+	fs.StringVar(&c.export.prefix, "prefix", "", "export only keys starting with `prefix`")
+}
+
+func importFlags(c *cli, fs *flag.FlagSet) {
+	fs.StringVar(&c.imp.prefix, "prefix", "", "import only keys starting with `prefix`")
+	fs.StringVar(&c.imp.conflict, "on-conflict", string(ConflictFail), "`policy` for existing keys (skip, overwrite, fail)")
+	fs.BoolVar(&c.imp.dryRun, "dry-run", false, "check the file and conflicts without writing")
+}
+
+func (c *cli) exportStore(args []string) error {
+	format, err := ParseExportFormat(c.export.format)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	var count int
+
+	if len(args) == 0 || args[0] == "-" {
+		count, err = exportTo(c.kv, os.Stdout, format, c.export.prefix)
+		if err != nil {
+			return err
+		}
+	} else if info, statErr := os.Stat(args[0]); statErr == nil && !info.Mode().IsRegular() {
+		// devices and pipes can't be replaced by a rename
+		count, err = exportToFile(c.kv, args[0], format, c.export.prefix)
+		if err != nil {
+			return err
+		}
+	} else {
+		// a failed export keeps the previous content of the file
+		err = writeFileAtomic(args[0], func(w io.Writer) error {
+			count, err = exportTo(c.kv, w, format, c.export.prefix)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	zlog.Info().Int("keys", count).Str("format", string(format)).Msg("Exported store.")
+
+	return nil
+}
+
+// exportTo writes the keys starting with prefix to out in format.
+func exportTo(store ExportStore, out io.Writer, format ExportFormat, prefix string) (int, error) {
+	w, err := NewRecordWriter(out, format)
+	if err != nil {
+		return 0, err
+	}
+
+	return Export(store, w, prefix)
+}
+
+// exportToFile writes the keys starting with prefix to the existing file
+// at path in format.
+func exportToFile(store ExportStore, path string, format ExportFormat, prefix string) (int, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := exportTo(store, file, format, prefix)
+
+	return count, errors.Join(err, file.Close())
+}
+
+// spoolInput copies in to a temporary file, the caller removes it.
+func spoolInput(in io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp("", "kv-import-*")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(file, in)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// verifyRecords reads every record of in, checking archive trailers, and
+// rewinds it.
+func verifyRecords(in io.ReadSeeker) error {
+	r, err := NewRecordReader(in)
+	if err != nil {
+		return err
+	}
+
+	for {
+		_, err = r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = in.Seek(0, io.SeekStart)
+
+	return err
+}
+
+func (c *cli) importStore(args []string) error {
+	policy, err := ParseConflictPolicy(c.imp.conflict)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	in := os.Stdin
+
+	if len(args) > 0 && args[0] != "-" {
+		in, err = os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	// a pipe is spooled to a file, so that it can be read twice
+	if _, err := in.Seek(0, io.SeekCurrent); err != nil {
+		in, err = spoolInput(in)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(in.Name())
+		defer in.Close()
+	}
+
+	// a truncated or corrupt file is rejected before any record is written
+	err = verifyRecords(in)
+	if err != nil {
+		return err
+	}
+
+	r, err := NewRecordReader(in)
+	if err != nil {
+		return err
+	}
+
+	opts := ImportOptions{Prefix: c.imp.prefix, Conflict: policy, DryRun: c.imp.dryRun}
+
+	stats, err := Import(c.kv, r, opts)
+
+	zlog.Info().
+		Int("imported", stats.Imported).
+		Int("overwritten", stats.Overwritten).
+		Int("skipped", stats.Skipped).
+		Bool("dry_run", opts.DryRun).
+		Msg("Imported store.")
+
+	return err
+}
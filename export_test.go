@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testRecords = []*Record{
+	{Key: "hello", Value: []byte("world"), ContentType: "text/plain"},
+	{Key: "empty", Value: []byte{}},
+	{Key: "binary", Value: []byte{0, 1, 2, 0xff}, ContentType: "application/octet-stream"},
+}
+
+func writeRecords(t *testing.T, format ExportFormat, records []*Record) []byte {
+	var buf bytes.Buffer
+
+	w, err := NewRecordWriter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rec := range records {
+		err = w.Write(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func readRecords(data []byte) ([]*Record, error) {
+	r, err := NewRecordReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*Record{}
+
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+
+		if err != nil {
+			return records, err
+		}
+
+		records = append(records, rec)
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	for _, format := range []ExportFormat{ExportJSONLines, ExportArchive} {
+		t.Run(string(format), func(t *testing.T) {
+			records, err := readRecords(writeRecords(t, format, testRecords))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(records, testRecords) {
+				t.Fatalf("read %+v, want %+v", records, testRecords)
+			}
+		})
+	}
+}
+
+func TestArchiveEmpty(t *testing.T) {
+	records, err := readRecords(writeRecords(t, ExportArchive, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 0 {
+		t.Fatalf("read %d records from an empty archive", len(records))
+	}
+}
+
+func TestArchiveRejectsTruncation(t *testing.T) {
+	data := writeRecords(t, ExportArchive, testRecords)
+
+	// every cut, including the ones at record boundaries, must fail
+	for size := len(archiveMagic); size < len(data); size++ {
+		_, err := readRecords(data[:size])
+		if err == nil {
+			t.Fatalf("archive cut at %d of %d bytes was accepted", size, len(data))
+		}
+	}
+}
+
+func TestArchiveRejectsCorruption(t *testing.T) {
+	data := writeRecords(t, ExportArchive, testRecords)
+
+	// flip a byte of the first value, "world"
+	corrupt := bytes.Clone(data)
+	corrupt[bytes.Index(corrupt, []byte("world"))] ^= 0xff
+
+	_, err := readRecords(corrupt)
+	if err == nil {
+		t.Fatal("corrupt archive was accepted")
+	}
+
+	_, err = readRecords(append(bytes.Clone(data), 0))
+	if err == nil {
+		t.Fatal("archive with data after its trailer was accepted")
+	}
+}
+
+// failingStore lists keys it then fails to get.
+type failingStore struct{}
+
+func (failingStore) List(prefix string) ([]string, error) {
+	return []string{"hello"}, nil
+}
+
+func (failingStore) GetWithContentType(key string) ([]byte, string, error) {
+	return nil, "", errors.New("plugin crashed")
+}
+
+func (failingStore) PutWithContentType(key string, value []byte, contentType string) error {
+	return errors.New("plugin crashed")
+}
+
+func TestFailedExportKeepsPreviousFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.kva")
+	previous := writeRecords(t, ExportArchive, testRecords)
+
+	err := os.WriteFile(path, previous, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		_, err := exportTo(failingStore{}, w, ExportArchive, "")
+		return err
+	})
+	if err == nil {
+		t.Fatal("export of a failing store succeeded")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, previous) {
+		t.Fatal("failed export changed the previous export")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("failed export left %d files, want only the previous export", len(entries))
+	}
+}
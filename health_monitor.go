@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+// HealthState is the readiness of the plugin as seen by the host.
+type HealthState int
+
+const (
+	HealthStarting HealthState = iota
+	HealthReady
+	HealthDegraded
+	HealthUnhealthy
+)
+
+func (h HealthState) String() string {
+	switch h {
+	case HealthStarting:
+		return "starting"
+	case HealthReady:
+		return "ready"
+	case HealthDegraded:
+		return "degraded"
+	default:
+		return "unhealthy"
+	}
+}
+
+type HealthMonitorOptions struct {
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// FailureThreshold is the number of consecutive failed checks after
+	// which the plugin is unhealthy, earlier failures only degrade it.
+	FailureThreshold int
+}
+
+func DefaultHealthMonitorOptions() HealthMonitorOptions {
+	return HealthMonitorOptions{
+		Interval:         5 * time.Second,
+		Timeout:          time.Second,
+		FailureThreshold: 3,
+	}
+}
+
+// HealthReport is a snapshot of the plugin health.
+type HealthReport struct {
+	State     HealthState
+	Reasons   []string
+	LastCheck time.Time
+	Failures  int // consecutive failed checks
+}
+
+// HealthMonitor periodically checks the plugin health and tracks its state.
+type HealthMonitor struct {
+	sup  *Supervisor
+	opts HealthMonitorOptions
+
+	mutex  sync.Mutex
+	report HealthReport
+}
+
+func NewHealthMonitor(sup *Supervisor, opts HealthMonitorOptions) *HealthMonitor {
+	return &HealthMonitor{
+		sup:    sup,
+		opts:   opts,
+		report: HealthReport{State: HealthStarting},
+	}
+}
+
+// Run checks the plugin health every interval until ctx is done.
+func (h *HealthMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		h.Check()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check performs a single health check and returns the updated report.
+func (h *HealthMonitor) Check() HealthReport {
+	health, err := h.check()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	prevState := h.report.State
+	h.report.LastCheck = time.Now()
+
+	switch {
+	case err != nil:
+		h.report.Failures++
+		h.report.Reasons = []string{err.Error()}
+		h.report.State = HealthDegraded
+
+		if h.report.Failures >= h.opts.FailureThreshold {
+			h.report.State = HealthUnhealthy
+		}
+
+	case health.Status == shared.HealthOK:
+		h.report.Failures = 0
+		h.report.Reasons = nil
+		h.report.State = HealthReady
+
+	case health.Status == shared.HealthDegraded:
+		h.report.Failures = 0
+		h.report.Reasons = health.Reasons
+		h.report.State = HealthDegraded
+
+	default:
+		h.report.Failures = 0
+		h.report.Reasons = health.Reasons
+		h.report.State = HealthUnhealthy
+	}
+
+	if h.report.State != prevState {
+		zlog.Info().
+			Str("from", prevState.String()).
+			Str("to", h.report.State.String()).
+			Strs("reasons", h.report.Reasons).
+			Msg("Plugin health state changed.")
+	}
+
+	return h.report
+}
+
+func (h *HealthMonitor) check() (shared.Health, error) {
+	kv, err := h.sup.Client()
+	if err != nil {
+		return shared.Health{}, err
+	}
+
+	return kv.Health(h.opts.Timeout)
+}
+
+// Status returns the last health report.
+func (h *HealthMonitor) Status() HealthReport {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.report
+}
+
+// Ready reports whether the plugin can take traffic.
+func (h *HealthMonitor) Ready() bool {
+	return h.Status().State == HealthReady
+}
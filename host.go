@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hashicorp/go-plugin"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/shared"
+	"google.golang.org/grpc"
+)
+
+// Host holds the state shared by every plugin client of one store.
+type Host struct {
+	store             string
+	spec              *PluginSpec
+	settings          *Settings
+	pluginSettings    *PluginSettings
+	lock              *PluginLock
+	tls               *shared.TLSFiles      // nil means go-plugin AutoMTLS
+	tracing           *shared.TracingConfig // nil means tracing is disabled
+	metrics           *PluginMetrics
+	logHelper         *LogHelper
+	logInjector       *LogInjector
+	stderrToLogWriter *StderrToLogWriter
+}
+
+func NewHost(store *StoreConfig, settings *Settings, lock *PluginLock, tls *shared.TLSFiles, tracing *shared.TracingConfig, loggers *Loggers) *Host {
+	mainLogger := loggers.Main.With().Str("store", store.Name).Logger()
+	pluginLogger := loggers.Plugin.With().Str("store", store.Name).Logger()
+
+	metrics := NewPluginMetrics(store.Name)
+	shared.MetricsRegistry.MustRegister(metrics)
+
+	return &Host{
+		store:             store.Name,
+		spec:              store.Spec,
+		settings:          settings,
+		pluginSettings:    NewPluginSettings(settings.Config().PluginConfig(store.Name)),
+		lock:              lock,
+		tls:               tls,
+		tracing:           tracing,
+		metrics:           metrics,
+		logHelper:         NewLogHelper(&pluginLogger),
+		logInjector:       NewLogInjector(&mainLogger),
+		stderrToLogWriter: NewStderrToLogWriter(&pluginLogger),
+	}
+}
+
+// Launch starts a new plugin process under a supervisor.
+func (h *Host) Launch(opts SupervisorOptions) (*Supervisor, error) {
+	checksum, err := pluginChecksum(h.spec, h.lock)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if h.tls != nil {
+		tlsConfig, err = h.tls.Config()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Both the KV connection and the broker connections serving LogHelper
+	// use this TLS config.
+	newConfig := func(spec *PluginSpec) (*plugin.ClientConfig, error) {
+		// checked again on the executed file, this catches a replaced
+		// binary before it is started
+		if checksum != nil {
+			err := VerifyChecksum(spec.BinaryPath(), checksum)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		cmd, err := spec.Command(checksum)
+		if err != nil {
+			return nil, err
+		}
+
+		config := &plugin.ClientConfig{
+			Logger:           h.logInjector,
+			HandshakeConfig:  shared.PluginHandshakeConfig(),
+			VersionedPlugins: shared.PluginVersionedClientConfig(),
+			Cmd:              cmd,
+			SkipHostEnv:      true,
+			SecureConfig:     spec.SecureConfig(checksum),
+			AutoMTLS:         tlsConfig == nil,
+			AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+			SyncStderr:       h.stderrToLogWriter,
+			GRPCDialOptions:  h.dialOptions(),
+		}
+
+		// plugins export their spans like the host does
+		if h.tracing != nil {
+			cmd.Env = append(cmd.Env, h.tracing.Env()...)
+		}
+
+		if tlsConfig != nil {
+			cmd.Env = append(cmd.Env, h.tls.Env()...)
+			config.TLSConfig = tlsConfig.Clone()
+		}
+
+		return config, nil
+	}
+
+	opts.LogHelper = h.logHelper
+	opts.HostConfig = h.pluginSettings
+	opts.Metrics = h.metrics
+
+	return h.start(newConfig, opts)
+}
+
+// Reattach connects to a plugin process started by `kv daemon`.
+func (h *Host) Reattach(state *DaemonState) (*Supervisor, error) {
+	reattach, err := state.ReattachConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if state.TLS == nil {
+		return nil, errors.New("daemon state has no TLS files")
+	}
+
+	tlsConfig, err := state.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	newConfig := func(spec *PluginSpec) (*plugin.ClientConfig, error) {
+		return &plugin.ClientConfig{
+			Logger:           h.logInjector,
+			HandshakeConfig:  shared.PluginHandshakeConfig(),
+			Plugins:          shared.PluginVersionedClientConfig()[state.ProtocolVersion],
+			Reattach:         reattach,
+			TLSConfig:        tlsConfig,
+			AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+			SyncStderr:       h.stderrToLogWriter,
+			GRPCDialOptions:  h.dialOptions(),
+		}, nil
+	}
+
+	opts := DefaultSupervisorOptions()
+	opts.Attached = true
+
+	return h.start(newConfig, opts)
+}
+
+// Connect reattaches to the daemon plugin if one is running, otherwise it
+// launches a new plugin process. Stale daemon state is removed.
+func (h *Host) Connect() (*Supervisor, error) {
+	statePath := DaemonStateFile(h.store)
+
+	state, err := LoadDaemonState(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if state != nil && state.Stale() {
+		zlog.Warn().Str("state_file", statePath).Stringer("state", state).Msg("Removing stale daemon state.")
+		state = nil
+
+		err = removeDaemonState(statePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if state != nil {
+		kv, err := h.Reattach(state)
+		if err == nil {
+			return kv, nil
+		}
+
+		zlog.Warn().Err(err).Stringer("state", state).Msg("Failed to reattach to daemon plugin, launching a new one.")
+	}
+
+	opts, err := h.settings.SupervisorOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Launch(opts)
+}
+
+// ReloadConfig reloads the host config file and updates the plugin settings
+// of the store, plugins watching them are notified of changed keys.
+func (h *Host) ReloadConfig() error {
+	err := h.settings.ReloadConfig()
+	if err != nil {
+		return err
+	}
+
+	changed := h.pluginSettings.Set(h.settings.Config().PluginConfig(h.store))
+
+	zlog.Info().Str("store", h.store).Strs("changed", changed).Msg("Reloaded host config.")
+
+	return nil
+}
+
+// ReloadConfigOnSignal reloads the host config file on SIGHUP until ctx is
+// done.
+func (h *Host) ReloadConfigOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+
+		err := h.ReloadConfig()
+		if err != nil {
+			zlog.Error().Err(err).Msg("Failed to reload host config, keeping the current one.")
+		}
+	}
+}
+
+// dialOptions returns the options of the plugin connection, recording
+// metrics and traces of KV calls.
+func (h *Host) dialOptions() []grpc.DialOption {
+	return append(shared.MetricsDialOptions(), shared.TracingDialOptions()...)
+}
+
+func (h *Host) start(newConfig func(spec *PluginSpec) (*plugin.ClientConfig, error), opts SupervisorOptions) (*Supervisor, error) {
+	kv := NewSupervisor(h.spec, newConfig, opts)
+
+	err := kv.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return kv, nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+const (
+	EnvConfigFile     = "KV_CONFIG"
+	DefaultConfigFile = "kv.json"
+)
+
+// HostConfig is the host config file. Every setting except "plugins" has an
+// environment variable of the same meaning, which takes precedence:
+//
+//	{
+//	  "plugin": {"path": "./kv-go-grpc", "args": [], "env": [], "dir": "",
+//	             "max_memory": 0, "max_open_files": 0},
+//	  "stores": [{"name": "cache", "plugin": {"dir": "/var/cache/kv"}}],
+//	  "log": {"level": "info", "format": "console", "output": "stderr",
+//	          "plugin_format": "json", "plugin_output": "/var/log/kv-plugin.log",
+//	          "max_size": 10485760, "max_age": "24h", "max_backups": 5},
+//	  "timeouts": {"shutdown": "5s", "health_interval": "5s", "health": "1s"},
+//	  "listen": ["tcp://127.0.0.1:7070"],
+//	  "serve_tls": {"cert": "", "key": "", "ca": ""},
+//	  "plugins": {"default": {"data_dir": "/var/lib/kv", "fsync": "always"}}
+//	}
+//
+// The "plugins" section holds the settings passed to the plugin of each
+// store at Init, keyed by store name.
+type HostConfig struct {
+	Plugin   PluginFileConfig             `json:"plugin"`
+	Stores   []StoreFileConfig            `json:"stores"`
+	Log      LogFileConfig                `json:"log"`
+	Timeouts TimeoutsFileConfig           `json:"timeouts"`
+	Listen   []string                     `json:"listen"`
+	ServeTLS ServeTLSFileConfig           `json:"serve_tls"`
+	Plugins  map[string]map[string]string `json:"plugins"`
+}
+
+type PluginFileConfig struct {
+	Path         string   `json:"path"`
+	Args         []string `json:"args"`
+	Env          []string `json:"env"`
+	Dir          string   `json:"dir"`
+	MaxMemory    uint64   `json:"max_memory"`
+	MaxOpenFiles uint64   `json:"max_open_files"`
+}
+
+type StoreFileConfig struct {
+	Name   string           `json:"name"`
+	Plugin PluginFileConfig `json:"plugin"`
+}
+
+type LogFileConfig struct {
+	Level        string `json:"level"`
+	Format       string `json:"format"`
+	Output       string `json:"output"`
+	PluginFormat string `json:"plugin_format"`
+	PluginOutput string `json:"plugin_output"`
+	MaxSize      uint64 `json:"max_size"`
+	MaxAge       string `json:"max_age"`
+	MaxBackups   uint64 `json:"max_backups"`
+}
+
+type ServeTLSFileConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	CA   string `json:"ca"`
+}
+
+type TimeoutsFileConfig struct {
+	Shutdown       string `json:"shutdown"`
+	HealthInterval string `json:"health_interval"`
+	Health         string `json:"health"`
+}
+
+// LoadHostConfigFile loads the config file at path. An empty path means
+// KV_CONFIG, or kv.json if it exists; without a file the config is empty.
+func LoadHostConfigFile(path string) (*HostConfig, error) {
+	explicit := path != ""
+	if !explicit {
+		path, explicit = os.LookupEnv(EnvConfigFile)
+	}
+
+	if !explicit {
+		path = DefaultConfigFile
+	}
+
+	config, err := LoadHostConfig(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return &HostConfig{}, nil
+	}
+
+	return config, err
+}
+
+func LoadHostConfig(path string) (*HostConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	config := &HostConfig{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// Env returns the file settings keyed by their environment variable names.
+func (c *HostConfig) Env() map[string]string {
+	env := map[string]string{
+		EnvLogLevel:        c.Log.Level,
+		EnvLogFormat:       c.Log.Format,
+		EnvLogOutput:       c.Log.Output,
+		EnvLogPluginFormat: c.Log.PluginFormat,
+		EnvLogPluginOutput: c.Log.PluginOutput,
+		EnvLogMaxAge:       c.Log.MaxAge,
+		EnvShutdownTimeout: c.Timeouts.Shutdown,
+		EnvHealthInterval:  c.Timeouts.HealthInterval,
+		EnvHealthTimeout:   c.Timeouts.Health,
+		EnvListen:          encodeList(c.Listen),
+		EnvServeTLSCert:    c.ServeTLS.Cert,
+		EnvServeTLSKey:     c.ServeTLS.Key,
+		EnvServeTLSCA:      c.ServeTLS.CA,
+	}
+
+	if c.Log.MaxSize > 0 {
+		env[EnvLogMaxSize] = strconv.FormatUint(c.Log.MaxSize, 10)
+	}
+
+	if c.Log.MaxBackups > 0 {
+		env[EnvLogMaxBackups] = strconv.FormatUint(c.Log.MaxBackups, 10)
+	}
+
+	c.Plugin.addEnv(env, "KV_")
+
+	names := []string{}
+	for _, store := range c.Stores {
+		names = append(names, store.Name)
+		store.Plugin.addEnv(env, storeEnvPrefix(store.Name))
+	}
+
+	env[EnvStores] = strings.Join(names, ",")
+
+	return env
+}
+
+// addEnv adds the plugin settings to env, prefix replaces "KV_" in the
+// KV_PLUGIN* variable names.
+func (c *PluginFileConfig) addEnv(env map[string]string, prefix string) {
+	set := func(name string, value string) {
+		env[prefix+strings.TrimPrefix(name, "KV_")] = value
+	}
+
+	set(EnvPluginPath, c.Path)
+	// lists are passed as JSON, so that items may hold spaces and commas
+	set(EnvPluginArgs, encodeList(c.Args))
+	set(EnvPluginEnv, encodeList(c.Env))
+	set(EnvPluginDir, c.Dir)
+
+	if c.MaxMemory > 0 {
+		set(EnvPluginMaxMemory, strconv.FormatUint(c.MaxMemory, 10))
+	}
+
+	if c.MaxOpenFiles > 0 {
+		set(EnvPluginMaxOpenFiles, strconv.FormatUint(c.MaxOpenFiles, 10))
+	}
+}
+
+// PluginConfig returns the plugin settings of a store, nil if it has none.
+func (c *HostConfig) PluginConfig(store string) map[string]string {
+	return c.Plugins[store]
+}
+
+// PluginSettings holds the plugin section of a store and serves it to the
+// plugin as shared.HostConfig. It is updated when the config file is
+// reloaded, and watchers are told about changed keys.
+type PluginSettings struct {
+	mutex   sync.Mutex
+	values  map[string]string
+	changed chan struct{} // closed and replaced on every update
+}
+
+func NewPluginSettings(values map[string]string) *PluginSettings {
+	return &PluginSettings{
+		values:  values,
+		changed: make(chan struct{}),
+	}
+}
+
+// Values returns the current settings, they must not be modified.
+func (s *PluginSettings) Values() map[string]string {
+	values, _ := s.snapshot()
+	return values
+}
+
+// Set replaces the settings and returns the keys whose value changed.
+func (s *PluginSettings) Set(values map[string]string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	changed := changedKeys(s.values, values, nil)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	s.values = values
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	return changed
+}
+
+func (s *PluginSettings) Get(key string) (shared.ConfigValue, error) {
+	values, _ := s.snapshot()
+	return configValue(values, key), nil
+}
+
+func (s *PluginSettings) Watch(ctx context.Context, keys []string, fn func(shared.ConfigValue) error) error {
+	values, changed := s.snapshot()
+
+	initial := keys
+	if len(initial) == 0 {
+		initial = changedKeys(nil, values, nil)
+	}
+
+	for _, key := range initial {
+		err := fn(configValue(values, key))
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+
+		var newValues map[string]string
+		newValues, changed = s.snapshot()
+
+		for _, key := range changedKeys(values, newValues, keys) {
+			err := fn(configValue(newValues, key))
+			if err != nil {
+				return err
+			}
+		}
+
+		values = newValues
+	}
+}
+
+func (s *PluginSettings) snapshot() (map[string]string, chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.values, s.changed
+}
+
+func configValue(values map[string]string, key string) shared.ConfigValue {
+	value, found := values[key]
+	return shared.ConfigValue{Key: key, Value: value, Found: found}
+}
+
+// changedKeys returns the sorted keys set or changed between old and new,
+// limited to keys unless it is empty.
+func changedKeys(old map[string]string, new map[string]string, keys []string) []string {
+	if len(keys) == 0 {
+		for key := range old {
+			keys = append(keys, key)
+		}
+
+		for key := range new {
+			if _, ok := old[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	changed := []string{}
+
+	for _, key := range keys {
+		oldValue, oldFound := old[key]
+		newValue, newFound := new[key]
+
+		if oldFound != newFound || oldValue != newValue {
+			changed = append(changed, key)
+		}
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
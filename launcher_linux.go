@@ -0,0 +1,132 @@
+//go:build linux
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// launcherSupported is true where the launcher can start plugins.
+const launcherSupported = true
+
+// envLauncher is set when the host binary is re-executed as the launcher of
+// a plugin, it holds the launcherSpec as JSON.
+const envLauncher = "KV_LAUNCHER"
+
+// launcherSpec tells the launcher which binary to execute and how.
+type launcherSpec struct {
+	Path   string         `json:"path"`
+	Limits ResourceLimits `json:"limits"`
+	SHA256 string         `json:"sha256,omitempty"` // pinned checksum, hex
+}
+
+// launcherCommand returns a command re-executing the host binary as a
+// launcher: it sets the rlimits of its own process and then execs the
+// plugin in place, so the limits apply from the first instruction of the
+// plugin and go-plugin tracks the plugin PID.
+func launcherCommand(spec launcherSpec, args []string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find host binary to launch plugin: %v", err)
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(self, args...)
+	cmd.Env = []string{envLauncher + "=" + string(data)}
+
+	return cmd, nil
+}
+
+// runLauncher execs the plugin if the process was started by
+// launcherCommand, it returns right away otherwise.
+func runLauncher() {
+	data, ok := os.LookupEnv(envLauncher)
+	if !ok {
+		return
+	}
+
+	err := launch(data)
+	fmt.Fprintf(os.Stderr, "kv launcher: %v\n", err)
+	os.Exit(1)
+}
+
+// launch only returns if the plugin could not be executed.
+func launch(data string) error {
+	var spec launcherSpec
+
+	err := json.Unmarshal([]byte(data), &spec)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", envLauncher, err)
+	}
+
+	err = os.Unsetenv(envLauncher)
+	if err != nil {
+		return err
+	}
+
+	// the binary is executed through the descriptor it was verified from,
+	// never reopened by path, so replacing the file at spec.Path after the
+	// check has no effect
+	file, err := os.Open(spec.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if spec.SHA256 != "" {
+		hash := sha256.New()
+
+		_, err = io.Copy(hash, file)
+		if err != nil {
+			return err
+		}
+
+		actual := hex.EncodeToString(hash.Sum(nil))
+		if actual != spec.SHA256 {
+			return fmt.Errorf("checksum mismatch for plugin %q: expected %s, got %s", spec.Path, spec.SHA256, actual)
+		}
+	}
+
+	err = setResourceLimits(spec.Limits)
+	if err != nil {
+		return err
+	}
+
+	// the process name becomes the descriptor number, argv keeps the path
+	argv := append([]string{spec.Path}, os.Args[1:]...)
+
+	return syscall.Exec(fmt.Sprintf("/proc/self/fd/%d", file.Fd()), argv, os.Environ())
+}
+
+// setResourceLimits sets rlimits on the current process, they are inherited
+// across exec.
+func setResourceLimits(limits ResourceLimits) error {
+	if limits.MaxMemoryBytes > 0 {
+		err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: limits.MaxMemoryBytes, Max: limits.MaxMemoryBytes})
+		if err != nil {
+			return fmt.Errorf("failed to set memory limit: %v", err)
+		}
+	}
+
+	// syscall.Setrlimit also keeps the Go runtime from restoring its own
+	// open files limit on exec
+	if limits.MaxOpenFiles > 0 {
+		err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: limits.MaxOpenFiles, Max: limits.MaxOpenFiles})
+		if err != nil {
+			return fmt.Errorf("failed to set open files limit: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// launcherSupported is true where the launcher can start plugins.
+const launcherSupported = false
+
+// launcherSpec tells the launcher which binary to execute and how.
+type launcherSpec struct {
+	Path   string
+	Limits ResourceLimits
+	SHA256 string
+}
+
+// launcherCommand is only supported on linux, resource limits can't be set
+// on the plugin process elsewhere.
+func launcherCommand(spec launcherSpec, args []string) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("resource limits are not supported on this platform")
+}
+
+func runLauncher() {}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// ErrInterrupted is returned by ReadLine when the user presses Ctrl-C.
+var ErrInterrupted = errors.New("interrupted")
+
+const maxHistory = 1000
+
+// LineEditor reads lines with history and tab completion when stdin is a
+// terminal, and plain lines otherwise.
+type LineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	terminal bool
+	history  []string
+
+	// Complete returns candidates for the word being typed, line is the
+	// text before it.
+	Complete func(line string, word string) []string
+}
+
+func NewLineEditor() *LineEditor {
+	return &LineEditor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stderr,
+		terminal: term.IsTerminal(int(os.Stdin.Fd())),
+	}
+}
+
+// Terminal reports whether lines are read from a terminal.
+func (e *LineEditor) Terminal() bool {
+	return e.terminal
+}
+
+// LoadHistory reads history lines from path, a missing file is ignored.
+func (e *LineEditor) LoadHistory(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.addHistory(line)
+		}
+	}
+
+	return nil
+}
+
+// SaveHistory writes the history lines to path.
+func (e *LineEditor) SaveHistory(path string) error {
+	data := strings.Join(e.history, "\n") + "\n"
+	return os.WriteFile(path, []byte(data), 0600)
+}
+
+func (e *LineEditor) addHistory(line string) {
+	if len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// ReadLine prints prompt and reads a line. It returns io.EOF at the end of
+// input or on Ctrl-D on an empty line.
+func (e *LineEditor) ReadLine(prompt string) (string, error) {
+	if !e.terminal {
+		return e.readPlainLine()
+	}
+
+	// raw mode reads input key by key, without echo
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(int(os.Stdin.Fd()), state)
+
+	line, err := e.edit(prompt)
+	fmt.Fprint(e.out, "\r\n")
+
+	if trimmed := strings.TrimSpace(line); err == nil && trimmed != "" {
+		e.addHistory(trimmed)
+	}
+
+	return line, err
+}
+
+func (e *LineEditor) readPlainLine() (string, error) {
+	line, err := e.in.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// edit runs the key loop of a terminal line.
+func (e *LineEditor) edit(prompt string) (string, error) {
+	buf := []rune{}
+	pos := 0
+	historyPos := len(e.history)
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+
+	setLine := func(line string) {
+		buf = []rune(line)
+		pos = len(buf)
+	}
+
+	redraw()
+
+	for {
+		r, err := e.readRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			return string(buf), nil
+
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C")
+			return "", ErrInterrupted
+
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				return "", io.EOF
+			}
+
+		case 1: // Ctrl-A
+			pos = 0
+
+		case 5: // Ctrl-E
+			pos = len(buf)
+
+		case 21: // Ctrl-U
+			buf = buf[pos:]
+			pos = 0
+
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+
+		case '\t':
+			buf, pos = e.complete(prompt, buf, pos)
+
+		case 27: // escape sequence
+			seq, err := e.readEscape()
+			if err != nil {
+				return "", err
+			}
+
+			switch seq {
+			case "[A", "OA": // up
+				if historyPos > 0 {
+					historyPos--
+					setLine(e.history[historyPos])
+				}
+			case "[B", "OB": // down
+				if historyPos < len(e.history)-1 {
+					historyPos++
+					setLine(e.history[historyPos])
+				} else {
+					historyPos = len(e.history)
+					setLine("")
+				}
+			case "[C", "OC": // right
+				if pos < len(buf) {
+					pos++
+				}
+			case "[D", "OD": // left
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "OH", "[1~", "[7~": // home
+				pos = 0
+			case "[F", "OF", "[4~", "[8~": // end
+				pos = len(buf)
+			case "[3~": // delete
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+
+		default:
+			if r >= ' ' {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+
+		redraw()
+	}
+}
+
+func (e *LineEditor) readRune() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err == nil && r == utf8.RuneError {
+		return ' ', nil
+	}
+
+	return r, err
+}
+
+// readEscape reads the rest of an escape sequence, e.g. "[A" for up or
+// "[3~" for delete. CSI sequences are read up to their final byte, so that
+// the parameters of keys that are not handled never end up in the line.
+func (e *LineEditor) readEscape() (string, error) {
+	b, err := e.in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	switch b {
+	case '[': // CSI: parameter and intermediate bytes, then a final byte
+		seq := []byte{b}
+
+		for {
+			b, err = e.in.ReadByte()
+			if err != nil {
+				return "", err
+			}
+
+			seq = append(seq, b)
+
+			if b >= 0x40 && b <= 0x7e {
+				return string(seq), nil
+			}
+		}
+
+	case 'O': // SS3: a single final byte
+		final, err := e.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		return string([]byte{b, final}), nil
+
+	default: // Alt with a key
+		return string(b), nil
+	}
+}
+
+// complete replaces the word before the cursor with the common prefix of its
+// candidates, and lists them when there are several.
+func (e *LineEditor) complete(prompt string, buf []rune, pos int) ([]rune, int) {
+	if e.Complete == nil {
+		return buf, pos
+	}
+
+	start := pos
+	for start > 0 && buf[start-1] != ' ' {
+		start--
+	}
+
+	word := string(buf[start:pos])
+	candidates := e.Complete(string(buf[:start]), word)
+
+	if len(candidates) == 0 {
+		return buf, pos
+	}
+
+	prefix := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+
+	if len(candidates) > 1 && prefix == word {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+
+	completed := append([]rune{}, buf[:start]...)
+	completed = append(completed, []rune(prefix)...)
+	newPos := len(completed)
+	completed = append(completed, buf[pos:]...)
+
+	return completed, newPos
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+
+	return prefix
+}
@@ -23,7 +23,7 @@ func NewLogInjector(logger *zerolog.Logger) *LogInjector {
 
 	opts := hclog.LoggerOptions{
 		Name:   "plugin",
-		Output: os.Stdout,
+		Output: os.Stderr,
 		Level:  hcLogLevel,
 	}
 
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	EnvLogPluginFormat = "KV_LOG_PLUGIN_FORMAT"
+	EnvLogPluginOutput = "KV_LOG_PLUGIN_OUTPUT"
+	EnvLogMaxSize      = "KV_LOG_MAX_SIZE"
+	EnvLogMaxAge       = "KV_LOG_MAX_AGE"
+	EnvLogMaxBackups   = "KV_LOG_MAX_BACKUPS"
+)
+
+// Loggers are the host root loggers: Main for the host itself (app=main)
+// and Plugin for log lines coming from plugins (app=plugin). They share a
+// sink unless plugin logs are configured to go elsewhere.
+type Loggers struct {
+	Main   *zerolog.Logger
+	Plugin *zerolog.Logger
+}
+
+// configureLoggers creates the host loggers from the KV_LOG_* settings.
+// KV_LOG_PLUGIN_FORMAT and KV_LOG_PLUGIN_OUTPUT default to the main ones.
+func configureLoggers(settings *Settings) (*Loggers, error) {
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+
+	level := zerolog.TraceLevel
+	if str := settings.Get(EnvLogLevel); str != "" {
+		var err error
+
+		level, err = zerolog.ParseLevel(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", EnvLogLevel, str, err)
+		}
+	}
+
+	zerolog.SetGlobalLevel(level)
+
+	rotation, err := logRotationFromSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	mainFormat := settings.Get(EnvLogFormat)
+	mainOutput := settings.Get(EnvLogOutput)
+
+	// main and plugin logs written to the same file share its rotator
+	files := map[string]*RotatingFile{}
+
+	mainWriter, err := newLogWriter(EnvLogFormat, mainFormat, mainOutput, rotation, files)
+	if err != nil {
+		return nil, err
+	}
+
+	main := zerolog.New(mainWriter).With().Timestamp().Logger()
+	loggers := &Loggers{Main: &main, Plugin: &main}
+
+	pluginFormat := settings.Get(EnvLogPluginFormat)
+	pluginOutput := settings.Get(EnvLogPluginOutput)
+
+	if pluginFormat == "" && pluginOutput == "" {
+		return loggers, nil
+	}
+
+	if pluginFormat == "" {
+		pluginFormat = mainFormat
+	}
+
+	if pluginOutput == "" {
+		pluginOutput = mainOutput
+	}
+
+	pluginWriter, err := newLogWriter(EnvLogPluginFormat, pluginFormat, pluginOutput, rotation, files)
+	if err != nil {
+		return nil, err
+	}
+
+	plugin := zerolog.New(pluginWriter).With().Timestamp().Logger()
+	loggers.Plugin = &plugin
+
+	return loggers, nil
+}
+
+// newLogWriter returns a writer formatting log events and sending them to
+// output: stderr (default), stdout, syslog or a file path. Stdout is left
+// to command results unless logs are explicitly sent there. Files already
+// opened in files, keyed by absolute path, are reused.
+func newLogWriter(formatEnv string, format string, output string, rotation LogRotation, files map[string]*RotatingFile) (zerolog.LevelWriter, error) {
+	var out zerolog.LevelWriter
+	color := false
+
+	switch output {
+	case "", "stderr":
+		out, color = zerolog.LevelWriterAdapter{Writer: os.Stderr}, true
+	case "stdout":
+		out, color = zerolog.LevelWriterAdapter{Writer: os.Stdout}, true
+	case "syslog":
+		var err error
+
+		out, err = newSyslogWriter()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+	default:
+		path, err := filepath.Abs(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log output: %w", err)
+		}
+
+		file, ok := files[path]
+		if !ok {
+			file, err = OpenRotatingFile(path, rotation)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open log output: %w", err)
+			}
+
+			files[path] = file
+		}
+
+		out = zerolog.LevelWriterAdapter{Writer: file}
+	}
+
+	switch format {
+	case "", "console":
+		return &formattingWriter{out: out, format: consoleFormat(!color)}, nil
+	case "json":
+		return out, nil
+	case "logfmt":
+		return &formattingWriter{out: out, format: logfmtFormat}, nil
+	default:
+		return nil, fmt.Errorf("invalid %s value %q, use console, json or logfmt", formatEnv, format)
+	}
+}
+
+// formattingWriter converts zerolog JSON events before writing them, keeping
+// the level so that syslog priorities still follow it.
+type formattingWriter struct {
+	out    zerolog.LevelWriter
+	format func(event []byte) ([]byte, error)
+}
+
+func (w *formattingWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *formattingWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	formatted, err := w.format(p)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.out.WriteLevel(level, formatted)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func consoleFormat(noColor bool) func(event []byte) ([]byte, error) {
+	return func(event []byte) ([]byte, error) {
+		buf := &bytes.Buffer{}
+		console := zerolog.ConsoleWriter{Out: buf, TimeFormat: time.StampMicro, NoColor: noColor}
+
+		_, err := console.Write(event)
+
+		return buf.Bytes(), err
+	}
+}
+
+// logfmtFormat renders an event as time, level and message followed by the
+// other fields in key order.
+func logfmtFormat(event []byte) ([]byte, error) {
+	fields := map[string]interface{}{}
+
+	decoder := json.NewDecoder(bytes.NewReader(event))
+	decoder.UseNumber()
+
+	err := decoder.Decode(&fields)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode log event: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	write := func(key string, value interface{}) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(value))
+	}
+
+	first := []string{zerolog.TimestampFieldName, zerolog.LevelFieldName, zerolog.MessageFieldName}
+
+	for _, key := range first {
+		if value, ok := fields[key]; ok {
+			write(key, value)
+			delete(fields, key)
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		write(key, fields[key])
+	}
+
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
+
+func logfmtValue(value interface{}) string {
+	var str string
+
+	switch v := value.(type) {
+	case string:
+		str = v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		data, _ := json.Marshal(v)
+		str = string(data)
+	}
+
+	if str == "" || strings.ContainsAny(str, " =\"\t\n") {
+		return strconv.Quote(str)
+	}
+
+	return str
+}
+
+func logRotationFromSettings(settings *Settings) (LogRotation, error) {
+	rotation := LogRotation{}
+
+	var err error
+	rotation.MaxSize, err = parseLimit(EnvLogMaxSize, settings.Get(EnvLogMaxSize))
+	if err != nil {
+		return rotation, err
+	}
+
+	maxBackups, err := parseLimit(EnvLogMaxBackups, settings.Get(EnvLogMaxBackups))
+	if err != nil {
+		return rotation, err
+	}
+
+	rotation.MaxBackups = int(maxBackups)
+
+	rotation.MaxAge, err = settings.Duration(EnvLogMaxAge, 0)
+
+	return rotation, err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLoggersShareRotation(t *testing.T) {
+	const maxSize = 512
+
+	tests := []struct {
+		name         string
+		pluginOutput string         // relative to the test dir
+		wantLines    map[string]int // lines per log file, rotated ones included
+	}{
+		{
+			name:      "same file",
+			wantLines: map[string]int{"kv.log": 200},
+		},
+		{
+			name:         "same file by another path",
+			pluginOutput: "logs/../kv.log",
+			wantLines:    map[string]int{"kv.log": 200},
+		},
+		{
+			name:         "other file",
+			pluginOutput: "plugin.log",
+			wantLines:    map[string]int{"kv.log": 100, "plugin.log": 100},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			pluginOutput := ""
+			if tc.pluginOutput != "" {
+				pluginOutput = dir + string(filepath.Separator) + tc.pluginOutput
+			}
+
+			settings := &Settings{flags: map[string]string{
+				EnvLogLevel:        "debug",
+				EnvLogFormat:       "logfmt",
+				EnvLogOutput:       filepath.Join(dir, "kv.log"),
+				EnvLogPluginFormat: "json",
+				EnvLogPluginOutput: pluginOutput,
+				EnvLogMaxSize:      fmt.Sprint(maxSize),
+			}}
+
+			loggers, err := configureLoggers(settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { zerolog.SetGlobalLevel(zerolog.TraceLevel) })
+
+			for i := 0; i < 100; i++ {
+				loggers.Main.Info().Int("line", i).Msg("Main log line.")
+				loggers.Plugin.Info().Int("line", i).Msg("Plugin log line.")
+			}
+
+			for name, want := range tc.wantLines {
+				files, err := filepath.Glob(filepath.Join(dir, name+"*"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(files) < 2 {
+					t.Errorf("%s was not rotated: %v", name, files)
+				}
+
+				lines := 0
+
+				for _, file := range files {
+					data, err := os.ReadFile(file)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					// a rotator per logger would let the file grow past the
+					// limit with the lines of both
+					if len(data) > maxSize {
+						t.Errorf("%s has %d bytes, want at most %d", file, len(data), maxSize)
+					}
+
+					lines += bytes.Count(data, []byte("\n"))
+				}
+
+				if lines != want {
+					t.Errorf("%s has %d lines, want %d", name, lines, want)
+				}
+			}
+		})
+	}
+}
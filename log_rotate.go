@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LogRotation configures rotation of log files, zero values disable the
+// corresponding limit.
+type LogRotation struct {
+	MaxSize    uint64        // rotate when the file would grow past this size
+	MaxAge     time.Duration // rotate when the file is older than this
+	MaxBackups int           // number of rotated files to keep, 0 keeps all
+}
+
+// RotatingFile is an append-only log file rotated by size and age. Rotated
+// files get a timestamp suffix, e.g. kv.log.20231020-150405.
+type RotatingFile struct {
+	path     string
+	rotation LogRotation
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   uint64
+	opened time.Time
+}
+
+func OpenRotatingFile(path string, rotation LogRotation) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, rotation: rotation}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.shouldRotate(len(p)) {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += uint64(n)
+
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.file.Close()
+}
+
+func (f *RotatingFile) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+
+	if f.rotation.MaxSize > 0 && f.size+uint64(n) > f.rotation.MaxSize {
+		return true
+	}
+
+	return f.rotation.MaxAge > 0 && time.Since(f.opened) >= f.rotation.MaxAge
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = uint64(info.Size())
+	f.opened = time.Now()
+
+	// an existing file's age is unknown, count it from its last change
+	if info.Size() > 0 {
+		f.opened = info.ModTime()
+	}
+
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	backup := f.path + "." + time.Now().Format("20060102-150405.000000")
+
+	err = os.Rename(f.path, backup)
+	if err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	err = f.open()
+	if err != nil {
+		return err
+	}
+
+	return f.removeOldBackups()
+}
+
+func (f *RotatingFile) removeOldBackups() error {
+	if f.rotation.MaxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(f.path + ".*-*")
+	if err != nil {
+		return err
+	}
+
+	// timestamp suffixes sort chronologically
+	sort.Strings(backups)
+
+	for len(backups) > f.rotation.MaxBackups {
+		err = os.Remove(backups[0])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
@@ -4,15 +4,16 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
-	"time"
 
-	"github.com/hashicorp/go-plugin"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
 	"github.com/tinybit/go-plugin-log-example/shared"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -35,101 +36,111 @@ func NewLogHelper(logger *zerolog.Logger) *LogHelper {
 }
 
 func (l *LogHelper) Log(level int, msg string) error {
-	l.logger.Info().Msg(msg)
-	return nil
+	return l.LogContext(context.Background(), level, msg)
 }
 
-func run() error {
-	logger := configureLogger()
-	shared.MainLogHelper = NewLogHelper(logger)
-	logInjector := NewLogInjector(logger)
-	stderrToLogWriter := NewStderrToLogWriter(logger)
-	zlog.Logger = logger.With().Str("app", MainProcessLogLabel).Logger()
-
-	zlog.Info().Msg("Started main process.")
-
-	// We're a host. Start by launching the plugin process.
-	pluginInstance := &shared.KVGRPCPlugin{}
-
-	client := plugin.NewClient(&plugin.ClientConfig{
-		Logger:           logInjector,
-		HandshakeConfig:  shared.PluginHandshakeConfig(),
-		Plugins:          shared.PluginMapClientConfig(pluginInstance),
-		Cmd:              exec.Command("sh", "-c", os.Getenv("KV_PLUGIN")),
-		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
-		SyncStderr:       stderrToLogWriter,
-	})
-	defer client.Kill()
-
-	// Connect via RPC
-	rpcClient, err := client.Client()
-	if err != nil {
-		return err
+// LogContext logs a plugin message with the request ID of the call it was
+// logged in, and its trace and span IDs if it was traced.
+func (l *LogHelper) LogContext(ctx context.Context, level int, msg string) error {
+	event := l.logger.WithLevel(zerologLevel(shared.LogLevel(level)))
+
+	if requestID := shared.RequestIDFromContext(ctx); requestID != "" {
+		event = event.Str("request_id", requestID)
 	}
 
-	// Request the plugin
-	raw, err := rpcClient.Dispense(shared.PluginID)
-	if err != nil {
-		return err
+	sc := trace.SpanContextFromContext(ctx)
+	if sc.IsValid() {
+		event = event.Stringer("trace_id", sc.TraceID()).Stringer("span_id", sc.SpanID())
 	}
 
-	// We should have a KV store now! This feels like a normal interface
-	// implementation but is in fact over an RPC connection.
-	kv := raw.(shared.KV)
+	event.Msg(msg)
 
-	// ping first
-	err = kv.Ping()
-	if err != nil {
-		return err
+	return nil
+}
+
+// zerologLevel maps plugin log levels to zerolog ones, unspecified levels
+// are logged as info.
+func zerologLevel(level shared.LogLevel) zerolog.Level {
+	switch level {
+	case shared.LogLevelTrace:
+		return zerolog.TraceLevel
+	case shared.LogLevelDebug:
+		return zerolog.DebugLevel
+	case shared.LogLevelWarn:
+		return zerolog.WarnLevel
+	case shared.LogLevelError:
+		return zerolog.ErrorLevel
+	default:
+		return zerolog.InfoLevel
 	}
+}
+
+// pluginChecksum returns the pinned checksum of the plugin binary. Without
+// a lockfile it fails unless KV_PLUGIN_UNVERIFIED is set, and returns nil.
+func pluginChecksum(spec *PluginSpec, lock *PluginLock) ([]byte, error) {
+	path := spec.BinaryPath()
+
+	if lock == nil {
+		if !UnverifiedPluginsAllowed() {
+			err := fmt.Errorf("no plugin lockfile found, set %s or %s=1", EnvPluginLockFile, EnvPluginUnverified)
+			zlog.Error().Err(err).Str("path", path).Msg("Refusing to launch unverified plugin binary.")
+			return nil, err
+		}
 
-	// init plugin
-	err = pluginInstance.ClientPtr.Initialize()
+		zlog.Warn().Str("path", path).Msg("No plugin lockfile found, launching unverified plugin binary.")
+		return nil, nil
+	}
+
+	pinned, err := lock.Checksum(path)
 	if err != nil {
-		return err
+		zlog.Error().Err(err).Msg("Refusing to launch unpinned plugin binary.")
+		return nil, err
 	}
 
-	os.Args = os.Args[1:]
-	switch os.Args[0] {
-	case "get":
-		result, err := kv.Get(os.Args[1])
-		if err != nil {
-			return err
-		}
+	// the launcher, or go-plugin, checks the binary against the pinned
+	// checksum before running it
+	zlog.Info().Str("path", path).Str("sha256", hex.EncodeToString(pinned)).Msg("Launching plugin binary pinned in lockfile.")
 
-		zlog.Info().Msgf("Plugin get call result: %v", string(result))
+	return pinned, nil
+}
+
+func verifyPlugins(stores []*StoreConfig, lock *PluginLock) error {
+	if lock == nil {
+		return fmt.Errorf("no plugin lockfile found, set %s", EnvPluginLockFile)
+	}
+
+	var errs []error
 
-	case "put":
-		err := kv.Put(os.Args[1], []byte(os.Args[2]))
+	for _, store := range stores {
+		checksum, err := lock.Verify(store.Spec.BinaryPath())
 		if err != nil {
-			return err
+			zlog.Error().Err(err).Str("store", store.Name).Msg("Plugin verification failed.")
+			errs = append(errs, fmt.Errorf("store %q: %w", store.Name, err))
+			continue
 		}
 
-	default:
-		return fmt.Errorf("please only use 'get' or 'put', given: %q", os.Args[0])
+		zlog.Info().Str("store", store.Name).Str("path", store.Spec.BinaryPath()).Str("sha256", checksum).Msg("Plugin binary matches pinned checksum.")
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 func main() {
-	if err := run(); err != nil {
-		fmt.Printf("error: %+v\n", err)
-		os.Exit(1)
-	}
+	// a host binary re-executed to launch a plugin never gets further
+	runLauncher()
 
-	os.Exit(0)
-}
+	err := run()
 
-func configureLogger() *zerolog.Logger {
-	zerolog.TimeFieldFormat = time.RFC3339Nano
-	zerolog.SetGlobalLevel(zerolog.TraceLevel)
-
-	// Setup the default logger with global context
-	output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.StampMicro}
-	logger := zerolog.New(output).With().Timestamp().Logger()
+	var usageErr *UsageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
 
-	// logger := zlog.With().Timestamp().Logger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %+v\n", err)
+		os.Exit(1)
+	}
 
-	return &logger
+	os.Exit(0)
 }
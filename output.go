@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const EnvOutputFormat = "KV_OUTPUT_FORMAT"
+
+// OutputFormat selects how command results are written to stdout, logs
+// never go there unless explicitly configured.
+type OutputFormat string
+
+const (
+	OutputRaw    OutputFormat = "raw" // values as is, other results as text
+	OutputJSON   OutputFormat = "json"
+	OutputHex    OutputFormat = "hex"
+	OutputBase64 OutputFormat = "base64"
+)
+
+func ParseOutputFormat(str string) (OutputFormat, error) {
+	switch format := OutputFormat(str); format {
+	case "":
+		return OutputRaw, nil
+	case OutputRaw, OutputJSON, OutputHex, OutputBase64:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid output format %q, use raw, json, hex or base64", str)
+	}
+}
+
+// Output writes command results.
+type Output struct {
+	w      io.Writer
+	format OutputFormat
+}
+
+func NewOutput(w io.Writer, format OutputFormat) *Output {
+	return &Output{w: w, format: format}
+}
+
+// Value writes a value. Raw values are written without a trailing newline,
+// so that redirecting the output yields exactly the stored bytes. JSON
+// values are base64 encoded.
+func (o *Output) Value(key string, value []byte, contentType string) error {
+	var err error
+
+	switch o.format {
+	case OutputJSON:
+		err = o.json(struct {
+			Key         string `json:"key"`
+			Value       []byte `json:"value"`
+			ContentType string `json:"content_type,omitempty"`
+		}{key, value, contentType})
+	case OutputHex:
+		_, err = fmt.Fprintln(o.w, hex.EncodeToString(value))
+	case OutputBase64:
+		_, err = fmt.Fprintln(o.w, base64.StdEncoding.EncodeToString(value))
+	default:
+		_, err = o.w.Write(value)
+	}
+
+	return err
+}
+
+// Keys writes keys one per line, encoded like values, or as a JSON array.
+func (o *Output) Keys(keys []string) error {
+	if o.format == OutputJSON {
+		return o.json(keys)
+	}
+
+	for _, key := range keys {
+		line := key
+
+		switch o.format {
+		case OutputHex:
+			line = hex.EncodeToString([]byte(key))
+		case OutputBase64:
+			line = base64.StdEncoding.EncodeToString([]byte(key))
+		}
+
+		_, err := fmt.Fprintln(o.w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Result writes v as JSON in json format, and text otherwise.
+func (o *Output) Result(v interface{}, text string) error {
+	if o.format == OutputJSON {
+		return o.json(v)
+	}
+
+	_, err := fmt.Fprintln(o.w, text)
+
+	return err
+}
+
+func (o *Output) json(v interface{}) error {
+	encoder := json.NewEncoder(o.w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
@@ -0,0 +1,168 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+// FsyncMode controls when written files are fsynced.
+type FsyncMode string
+
+const (
+	FsyncNever  FsyncMode = "never"  // leave it to the OS
+	FsyncClose  FsyncMode = "close"  // fsync files written since start on Close
+	FsyncAlways FsyncMode = "always" // fsync every file before Put returns
+)
+
+// KeyEncoding controls how keys are mapped to file names.
+type KeyEncoding string
+
+const (
+	KeyEncodingRaw    KeyEncoding = "raw"
+	KeyEncodingHex    KeyEncoding = "hex"
+	KeyEncodingBase64 KeyEncoding = "base64" // URL-safe, without padding
+)
+
+// Config holds the file backend settings passed by the host at Init.
+// LogLevel and CompactionInterval may change at runtime, the plugin watches
+// them through the host config service.
+type Config struct {
+	DataDir            string
+	Fsync              FsyncMode
+	KeyEncoding        KeyEncoding
+	LogLevel           hclog.Level
+	CompactionInterval time.Duration // 0 disables compaction
+}
+
+func DefaultConfig() Config {
+	return Config{
+		DataDir:     ".",
+		Fsync:       FsyncClose,
+		KeyEncoding: KeyEncodingRaw,
+		LogLevel:    hclog.Debug,
+	}
+}
+
+// ParseConfig validates settings and applies them over the defaults, errors
+// wrap shared.ErrInvalidConfig so the host can tell them apart.
+func ParseConfig(settings map[string]string) (Config, error) {
+	config := DefaultConfig()
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		err := config.set(key, settings[key])
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	info, err := os.Stat(config.DataDir)
+	if err != nil {
+		return Config{}, fmt.Errorf("%w: data_dir: %v", shared.ErrInvalidConfig, err)
+	}
+
+	if !info.IsDir() {
+		return Config{}, fmt.Errorf("%w: data_dir %s is not a directory", shared.ErrInvalidConfig, config.DataDir)
+	}
+
+	return config, nil
+}
+
+// set validates and applies one setting.
+func (c *Config) set(key string, value string) error {
+	switch key {
+	case "data_dir":
+		c.DataDir = value
+
+	case "fsync":
+		c.Fsync = FsyncMode(value)
+		if c.Fsync != FsyncNever && c.Fsync != FsyncClose && c.Fsync != FsyncAlways {
+			return fmt.Errorf("%w: fsync must be never, close or always, got %q", shared.ErrInvalidConfig, value)
+		}
+
+	case "key_encoding":
+		c.KeyEncoding = KeyEncoding(value)
+		if c.KeyEncoding != KeyEncodingRaw && c.KeyEncoding != KeyEncodingHex && c.KeyEncoding != KeyEncodingBase64 {
+			return fmt.Errorf("%w: key_encoding must be raw, hex or base64, got %q", shared.ErrInvalidConfig, value)
+		}
+
+	case "log_level":
+		c.LogLevel = hclog.LevelFromString(value)
+		if c.LogLevel == hclog.NoLevel {
+			return fmt.Errorf("%w: invalid log_level %q", shared.ErrInvalidConfig, value)
+		}
+
+	case "compaction_interval":
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return fmt.Errorf("%w: invalid compaction_interval %q", shared.ErrInvalidConfig, value)
+		}
+
+		c.CompactionInterval = interval
+
+	default:
+		return fmt.Errorf("%w: unknown setting %q", shared.ErrInvalidConfig, key)
+	}
+
+	return nil
+}
+
+// checkKey returns an error if key is not valid, or if its file would not
+// be a direct child of the keys dir.
+func (c Config) checkKey(key string) error {
+	err := shared.ValidateKey(key)
+	if err != nil {
+		return err
+	}
+
+	// raw keys are used as file names as is
+	name := "kvmeta_" + c.encodeKey(key)
+	if filepath.Dir(filepath.Join(c.keysDir(), name)) != c.keysDir() {
+		return fmt.Errorf("%w: invalid key %q: its file would be outside of the data dir", shared.ErrInvalidArgument, key)
+	}
+
+	return nil
+}
+
+func (c Config) encodeKey(key string) string {
+	switch c.KeyEncoding {
+	case KeyEncodingHex:
+		return hex.EncodeToString([]byte(key))
+	case KeyEncodingBase64:
+		return base64.RawURLEncoding.EncodeToString([]byte(key))
+	default:
+		return key
+	}
+}
+
+func (c Config) decodeKey(name string) (string, error) {
+	var key []byte
+	var err error
+
+	switch c.KeyEncoding {
+	case KeyEncodingHex:
+		key, err = hex.DecodeString(name)
+	case KeyEncodingBase64:
+		key, err = base64.RawURLEncoding.DecodeString(name)
+	default:
+		return name, nil
+	}
+
+	return string(key), err
+}
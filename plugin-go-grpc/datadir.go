@@ -0,0 +1,147 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Keys are stored in a generation directory of the generations dir, the
+// current one being the target of its "current" symlink. Restore writes a
+// new generation and switches the symlink with a single rename, so the
+// store is either entirely the old or entirely the new one, even after a
+// crash.
+//
+// Only directories of the generations dir are ever removed, and only once
+// they are marked retired, so that neither other files of the data dir nor
+// the staging generation of a restore running in another plugin process
+// sharing the data dir are touched.
+const (
+	generationsDirName = "kv-generations"
+	currentLink        = "current"
+
+	generationPrefix = "gen-"     // complete, current or about to be
+	stagingPrefix    = "staging-" // written by a restore in progress
+	retiredPrefix    = "retired-" // replaced by a restore, to be removed
+
+	// legacyGeneration receives the keys of data dirs written before
+	// generations existed.
+	legacyGeneration = generationPrefix + "0"
+)
+
+// generationsDir returns the directory holding the generations.
+func (c Config) generationsDir() string {
+	return filepath.Join(c.DataDir, generationsDirName)
+}
+
+// keysDir returns the directory holding the value and content type files.
+func (c Config) keysDir() string {
+	return filepath.Join(c.generationsDir(), currentLink)
+}
+
+// openDataDir prepares the generations dir of config: it moves keys stored
+// directly in the data dir to a first generation, and removes generations
+// retired by restores interrupted before they could remove them.
+func openDataDir(config Config) error {
+	dir := config.generationsDir()
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("invalid data dir %s: %w", config.DataDir, err)
+	}
+
+	_, err = os.Readlink(filepath.Join(dir, currentLink))
+	if os.IsNotExist(err) {
+		// moving is repeated until the symlink exists, if interrupted
+		err = os.MkdirAll(filepath.Join(dir, legacyGeneration), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = moveFiles(config.DataDir, filepath.Join(dir, legacyGeneration))
+		if err != nil {
+			return err
+		}
+
+		err = switchGeneration(dir, legacyGeneration)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid data dir %s: %w", config.DataDir, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), retiredPrefix) {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// switchGeneration atomically points the current symlink of dir to the
+// generation directory named generation.
+func switchGeneration(dir string, generation string) error {
+	tmp := filepath.Join(dir, "."+currentLink+".tmp")
+
+	err := os.Remove(tmp)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Symlink(generation, tmp)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, currentLink))
+}
+
+// retireGeneration marks the generation named generation of dir as retired
+// and removes it.
+func retireGeneration(dir string, generation string) error {
+	retired := filepath.Join(dir, retiredPrefix+strings.TrimPrefix(generation, generationPrefix))
+
+	err := os.Rename(filepath.Join(dir, generation), retired)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(retired)
+}
+
+// moveFiles moves the value and content type files of from to dir to.
+func moveFiles(from string, to string) error {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "kv_") && !strings.HasPrefix(name, "kvmeta_") {
+			continue
+		}
+
+		err = os.Rename(filepath.Join(from, name), filepath.Join(to, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+type discardLogHelper struct{}
+
+func (discardLogHelper) Log(level int, msg string) error { return nil }
+
+// newTestKV returns a KV initialized with dataDir as its data dir.
+func newTestKV(t *testing.T, dataDir string) *KV {
+	t.Helper()
+
+	k := NewKV(hclog.NewNullLogger())
+
+	err := k.SetLogger(discardLogHelper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = k.Init(0, map[string]string{"data_dir": dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+// dirNames returns the sorted names of the entries of dir.
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func TestOpenDataDir(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       []string // created relative to the data dir
+		dirs        []string
+		current     string // target of the current symlink, if any
+		wantCurrent string
+		wantKeys    []string // files of the current generation
+		wantGens    []string // entries of the generations dir
+		wantDataDir []string // entries of the data dir
+	}{
+		{
+			name:        "new data dir",
+			wantCurrent: "gen-0",
+			wantKeys:    []string{},
+			wantGens:    []string{"current", "gen-0"},
+			wantDataDir: []string{"kv-generations"},
+		},
+		{
+			name:        "moves legacy keys",
+			files:       []string{"kv_a", "kvmeta_a", "kv_b", "notes.txt"},
+			dirs:        []string{"kv_dir"},
+			wantCurrent: "gen-0",
+			wantKeys:    []string{"kv_a", "kv_b", "kvmeta_a"},
+			wantGens:    []string{"current", "gen-0"},
+			wantDataDir: []string{"kv-generations", "kv_dir", "notes.txt"},
+		},
+		{
+			name:        "keeps current generation",
+			files:       []string{"kv_legacy", "kv-generations/gen-1/kv_a"},
+			current:     "gen-1",
+			wantCurrent: "gen-1",
+			wantKeys:    []string{"kv_a"},
+			wantGens:    []string{"current", "gen-1"},
+			wantDataDir: []string{"kv-generations", "kv_legacy"},
+		},
+		{
+			name:        "removes retired generations only",
+			files:       []string{"kv-generations/gen-1/kv_a", "kv-generations/retired-0/kv_a", "kv-generations/staging-2/kv_b", "kv-generations/notes.txt"},
+			dirs:        []string{"kv-generations/gen-user"},
+			current:     "gen-1",
+			wantCurrent: "gen-1",
+			wantKeys:    []string{"kv_a"},
+			wantGens:    []string{"current", "gen-1", "gen-user", "notes.txt", "staging-2"},
+			wantDataDir: []string{"kv-generations"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+
+			for _, name := range tc.dirs {
+				err := os.MkdirAll(filepath.Join(dataDir, name), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, name := range tc.files {
+				path := filepath.Join(dataDir, name)
+
+				err := os.MkdirAll(filepath.Dir(path), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = os.WriteFile(path, []byte(name), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := DefaultConfig()
+			config.DataDir = dataDir
+
+			if tc.current != "" {
+				err := switchGeneration(config.generationsDir(), tc.current)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := openDataDir(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			current, err := os.Readlink(config.keysDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if current != tc.wantCurrent {
+				t.Errorf("current generation is %s, want %s", current, tc.wantCurrent)
+			}
+
+			for _, check := range []struct {
+				dir  string
+				want []string
+			}{
+				{config.keysDir(), tc.wantKeys},
+				{config.generationsDir(), tc.wantGens},
+				{dataDir, tc.wantDataDir},
+			} {
+				if got := dirNames(t, check.dir); !reflect.DeepEqual(got, check.want) {
+					t.Errorf("%s has %v, want %v", check.dir, got, check.want)
+				}
+			}
+		})
+	}
+}
@@ -3,15 +3,21 @@
 
 /*
 	TODO:
-	- add ability to specify log levels
 	- add ability to specify key-value pairs in logs
 */
 
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-plugin"
@@ -21,11 +27,31 @@ import (
 // Here is a real implementation of KV that writes to a local file with
 // the key name and the contents are the value of the key.
 type KV struct {
-	logClient shared.LogHelper
+	logger     hclog.Logger
+	logClient  shared.LogHelper
+	config     Config
+	dirty      map[string]struct{} // keys written since the last fsync
+	compaction chan time.Duration  // compaction interval updates
+
+	// mutex is held for writing by changes to the data dir and for reading
+	// by lookups, so that they never see a partial restore
+	mutex    sync.RWMutex
+	snapshot *snapshot // in progress, nil if none
+
+	// log levels overriding config.LogLevel, hclog.NoLevel when unset: the
+	// one set by the host at runtime wins over the watched host config
+	levelMutex   sync.Mutex
+	runtimeLevel hclog.Level
+	hostLevel    hclog.Level
 }
 
-func NewKV() *KV {
-	return &KV{}
+func NewKV(logger hclog.Logger) *KV {
+	return &KV{
+		logger:     logger,
+		config:     DefaultConfig(),
+		dirty:      map[string]struct{}{},
+		compaction: make(chan time.Duration, 1),
+	}
 }
 
 func (k *KV) Ping() error {
@@ -33,9 +59,72 @@ func (k *KV) Ping() error {
 	return nil
 }
 
-func (k *KV) Init(uint32) error {
+func (k *KV) Init(_ uint32, settings map[string]string) error {
 	fmt.Fprintf(os.Stderr, "Plugin: got Init() call.\n")
 
+	config, err := ParseConfig(settings)
+	if err != nil {
+		return err
+	}
+
+	err = openDataDir(config)
+	if err != nil {
+		return err
+	}
+
+	k.config = config
+	k.applyLogLevel()
+	k.setCompactionInterval(config.CompactionInterval)
+
+	fmt.Fprintf(os.Stderr, "Plugin: using data dir %s, fsync %s, key encoding %s.\n", config.DataDir, config.Fsync, config.KeyEncoding)
+
+	return nil
+}
+
+// SetHostConfig watches the settings that can change without a restart.
+func (k *KV) SetHostConfig(config shared.HostConfig) error {
+	go func() {
+		err := config.Watch(context.Background(), []string{"log_level", "compaction_interval"}, k.applyHostConfig)
+		fmt.Fprintf(os.Stderr, "Plugin: stopped watching host config: %v\n", err)
+	}()
+
+	return nil
+}
+
+// applyHostConfig applies a changed setting, unset keys go back to their
+// default, or to the Init config for log_level, and invalid values are
+// ignored.
+func (k *KV) applyHostConfig(value shared.ConfigValue) error {
+	config := DefaultConfig()
+
+	if value.Found {
+		err := config.set(value.Key, value.Value)
+		if err != nil {
+			k.logClient.Log(int(shared.LogLevelWarn), fmt.Sprintf("Ignoring host config change: %v", err))
+			return nil
+		}
+	}
+
+	switch value.Key {
+	case "log_level":
+		k.levelMutex.Lock()
+		k.hostLevel = hclog.NoLevel
+		if value.Found {
+			k.hostLevel = config.LogLevel
+		}
+		k.levelMutex.Unlock()
+
+		k.applyLogLevel()
+	case "compaction_interval":
+		k.setCompactionInterval(config.CompactionInterval)
+	}
+
+	if value.Found {
+		k.logClient.Log(int(shared.LogLevelInfo), fmt.Sprintf("Applied host config %s=%q.", value.Key, value.Value))
+	} else {
+		k.logClient.Log(int(shared.LogLevelDebug), fmt.Sprintf("Using default for unset host config %s.", value.Key))
+	}
+
 	return nil
 }
 
@@ -43,43 +132,425 @@ func (k *KV) SetLogger(log shared.LogHelper) error {
 	fmt.Fprintf(os.Stderr, "Plugin: got SetLogger() call.\n")
 
 	k.logClient = log
-	k.logClient.Log(0, "This is log message from Plugin.SetLogger()!")
+	k.applyLogLevel()
+	k.logClient.Log(int(shared.LogLevelInfo), "This is log message from Plugin.SetLogger()!")
 
 	return nil
 }
 
-func (k *KV) Put(key string, value []byte) error {
-	fmt.Fprintf(os.Stderr, "Plugin: got Put() call.\n")
+// SetLogLevel is called when the host changes the plugin log level at
+// runtime, messages sent to the host are filtered by shared.GRPCServer. The
+// level wins over the config until the plugin exits.
+func (k *KV) SetLogLevel(level shared.LogLevel) error {
+	k.levelMutex.Lock()
+	k.runtimeLevel = hclog.Level(level)
+	k.levelMutex.Unlock()
+
+	k.applyLogLevel()
+	k.logClient.Log(int(shared.LogLevelInfo), fmt.Sprintf("Log level set to %v.", level))
+
+	return nil
+}
+
+// applyLogLevel sets the level of the plugin logger and of messages sent to
+// the host to the runtime level, the host config one or the Init config
+// one, whichever is set first.
+func (k *KV) applyLogLevel() {
+	k.levelMutex.Lock()
+	defer k.levelMutex.Unlock()
 
-	k.logClient.Log(0, "This is log message from Plugin.Put()!")
+	level := k.config.LogLevel
+	for _, override := range []hclog.Level{k.runtimeLevel, k.hostLevel} {
+		if override != hclog.NoLevel {
+			level = override
+			break
+		}
+	}
 
-	value = []byte(fmt.Sprintf("value [%v] in plugin-go-grpc", string(value)))
-	return os.WriteFile("kv_"+key, value, 0644)
+	k.logger.SetLevel(level)
+
+	if client, ok := k.logClient.(*shared.GRPCLogHelperClient); ok {
+		client.SetLevel(shared.LogLevel(level))
+	}
+}
+
+// log sends a message to the host, tied to the call traced by ctx.
+func (k *KV) log(ctx context.Context, level shared.LogLevel, msg string) {
+	if client, ok := k.logClient.(shared.ContextLogHelper); ok {
+		client.LogContext(ctx, int(level), msg)
+		return
+	}
+
+	k.logClient.Log(int(level), msg)
+}
+
+func (k *KV) Put(key string, value []byte) error {
+	return k.put(context.Background(), key, value, nil)
 }
 
 func (k *KV) Get(key string) ([]byte, error) {
+	value, _, err := k.GetContext(context.Background(), key)
+	return value, err
+}
+
+func (k *KV) PutWithContentType(key string, value []byte, contentType string) error {
+	return k.put(context.Background(), key, value, &contentType)
+}
+
+func (k *KV) GetWithContentType(key string) ([]byte, string, error) {
+	return k.GetContext(context.Background(), key)
+}
+
+// PutContext removes the content type of key when contentType is empty.
+func (k *KV) PutContext(ctx context.Context, key string, value []byte, contentType string) error {
+	if contentType == "" {
+		return k.put(ctx, key, value, nil)
+	}
+
+	return k.put(ctx, key, value, &contentType)
+}
+
+func (k *KV) GetContext(ctx context.Context, key string) ([]byte, string, error) {
 	fmt.Fprintf(os.Stderr, "Plugin: got Get() call.\n")
 
-	k.logClient.Log(0, "This is log message from Plugin.Get()!")
+	k.log(ctx, shared.LogLevelDebug, "This is log message from Plugin.Get()!")
+
+	err := k.config.checkKey(key)
+	if err != nil {
+		return nil, "", err
+	}
 
-	return os.ReadFile("kv_" + key)
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return k.get(key)
 }
 
-func main() {
-	serverInstance := NewKV()
+// put writes value and contentType, removing the content type of a previous
+// value if it is nil.
+func (k *KV) put(ctx context.Context, key string, value []byte, contentType *string) error {
+	fmt.Fprintf(os.Stderr, "Plugin: got Put() call.\n")
+
+	k.log(ctx, shared.LogLevelDebug, "This is log message from Plugin.Put()!")
+
+	err := k.config.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	return k.write(key, func() error {
+		// values are stored as is so that they round trip byte for byte
+		err := k.writeFile(k.path("kv_", key), value)
+		if err != nil {
+			return err
+		}
+
+		if k.config.Fsync == FsyncClose {
+			k.dirty[key] = struct{}{}
+		}
+
+		if contentType == nil {
+			err = os.Remove(k.path("kvmeta_", key))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			return nil
+		}
+
+		return k.writeFile(k.path("kvmeta_", key), []byte(*contentType))
+	})
+}
+
+func (k *KV) get(key string) ([]byte, string, error) {
+	value, err := os.ReadFile(k.path("kv_", key))
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType, err := os.ReadFile(k.path("kvmeta_", key))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, "", err
+	}
+
+	return value, string(contentType), nil
+}
+
+// write runs change of key with the mutex held, after saving the current
+// state of key for a snapshot in progress that has not copied it yet.
+func (k *KV) write(key string, change func() error) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	err := k.preserve(key)
+	if err != nil {
+		return err
+	}
+
+	return change()
+}
+
+func (k *KV) List(prefix string) ([]string, error) {
+	return k.ListContext(context.Background(), prefix)
+}
+
+func (k *KV) ListContext(ctx context.Context, prefix string) ([]string, error) {
+	fmt.Fprintf(os.Stderr, "Plugin: got List() call.\n")
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
 
+	return k.list(prefix)
+}
+
+func (k *KV) list(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(k.config.keysDir())
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+
+	for _, entry := range entries {
+		name, found := strings.CutPrefix(entry.Name(), "kv_")
+		if !found || entry.IsDir() {
+			continue
+		}
+
+		key, err := k.config.decodeKey(name)
+		if err != nil {
+			continue // not written with the current key encoding
+		}
+
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (k *KV) Delete(key string) error {
+	return k.DeleteContext(context.Background(), key)
+}
+
+func (k *KV) DeleteContext(ctx context.Context, key string) error {
+	fmt.Fprintf(os.Stderr, "Plugin: got Delete() call.\n")
+
+	k.log(ctx, shared.LogLevelDebug, "This is log message from Plugin.Delete()!")
+
+	err := k.config.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	return k.write(key, func() error {
+		err := os.Remove(k.path("kvmeta_", key))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return os.Remove(k.path("kv_", key))
+	})
+}
+
+func (k *KV) Capabilities() (shared.Capabilities, error) {
+	caps := shared.Capabilities{
+		Delete:      true,
+		List:        true,
+		ContentType: true,
+		Snapshot:    true,
+		Durability:  shared.DurabilityOS,
+	}
+
+	if k.config.Fsync == FsyncAlways {
+		caps.Durability = shared.DurabilityFsync
+	}
+
+	return caps, nil
+}
+
+// Health reports the store as degraded when the disk is full and unhealthy
+// when it is not writable at all.
+func (k *KV) Health() (shared.Health, error) {
+	file, err := os.CreateTemp(k.config.DataDir, ".kv_health_")
+	if err == nil {
+		file.Close()
+		os.Remove(file.Name())
+
+		return shared.Health{Status: shared.HealthOK}, nil
+	}
+
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return shared.Health{Status: shared.HealthDegraded, Reasons: []string{"disk full"}}, nil
+	case errors.Is(err, syscall.EROFS):
+		return shared.Health{Status: shared.HealthUnhealthy, Reasons: []string{"read-only filesystem"}}, nil
+	default:
+		return shared.Health{Status: shared.HealthUnhealthy, Reasons: []string{err.Error()}}, nil
+	}
+}
+
+// Close fsyncs all files written since start, it is called by the host
+// before the plugin process is killed.
+func (k *KV) Close() error {
+	fmt.Fprintf(os.Stderr, "Plugin: got Close() call.\n")
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	for key := range k.dirty {
+		err := syncFile(k.path("kv_", key))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		delete(k.dirty, key)
+	}
+
+	k.logClient.Log(int(shared.LogLevelInfo), "This is log message from Plugin.Close()!")
+
+	return nil
+}
+
+func (k *KV) setCompactionInterval(interval time.Duration) {
+	// drop a pending update not yet picked up by compactLoop
+	select {
+	case <-k.compaction:
+	default:
+	}
+
+	k.compaction <- interval
+}
+
+// compactLoop runs compact on the current compaction interval.
+func (k *KV) compactLoop() {
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+
+	for {
+		select {
+		case interval := <-k.compaction:
+			if ticker != nil {
+				ticker.Stop()
+				ticker, tick = nil, nil
+			}
+
+			if interval > 0 {
+				ticker = time.NewTicker(interval)
+				tick = ticker.C
+			}
+
+		case <-tick:
+			err := k.compact()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Plugin: compaction failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// compact removes content type files left without a value file.
+func (k *KV) compact() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	start := time.Now()
+	defer func() {
+		compactionDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	entries, err := os.ReadDir(k.config.keysDir())
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name, found := strings.CutPrefix(entry.Name(), "kvmeta_")
+		if !found || entry.IsDir() {
+			continue
+		}
+
+		_, err := os.Stat(filepath.Join(k.config.keysDir(), "kv_"+name))
+		if !os.IsNotExist(err) {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(k.config.keysDir(), entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		compactionRemoved.Inc()
+	}
+
+	return nil
+}
+
+func (k *KV) path(prefix string, key string) string {
+	return filepath.Join(k.config.keysDir(), prefix+k.config.encodeKey(key))
+}
+
+// writeFile writes data to path, fsyncing it when the fsync mode is always.
+func (k *KV) writeFile(path string, data []byte) error {
+	if k.config.Fsync != FsyncAlways {
+		return os.WriteFile(path, data, 0644)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	return errors.Join(err, file.Close())
+}
+
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
+func main() {
 	logger := hclog.New(&hclog.LoggerOptions{
 		Name:   "plugin",
 		Output: os.Stderr,
 		Level:  hclog.Debug,
 	})
 
+	tracing, err := shared.TracingConfigFromEnv(os.Getenv)
+	if err != nil {
+		logger.Error("invalid tracing config", "error", err)
+		os.Exit(1)
+	}
+
+	stopTracing, err := shared.ConfigureTracing(tracing, "kv-plugin-go-grpc")
+	if err != nil {
+		logger.Error("failed to configure tracing", "error", err)
+		os.Exit(1)
+	}
+	defer stopTracing(context.Background())
+
+	serverInstance := NewKV(logger)
+	registerMetrics(serverInstance)
+	go serverInstance.compactLoop()
+
 	plugin.Serve(&plugin.ServeConfig{
-		Logger:          logger,
-		HandshakeConfig: shared.PluginHandshakeConfig(),
-		Plugins:         shared.PluginMapServerConfig(serverInstance),
+		Logger:           logger,
+		HandshakeConfig:  shared.PluginHandshakeConfig(),
+		VersionedPlugins: shared.PluginVersionedServerConfig(serverInstance),
+		TLSProvider:      shared.PluginTLSProvider,
 
 		// A non-nil value here enables gRPC serving for this plugin...
-		GRPCServer: plugin.DefaultGRPCServer,
+		GRPCServer: shared.PluginGRPCServer,
 	})
 }
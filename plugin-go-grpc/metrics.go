@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+// Metrics of the file backend, registered in shared.MetricsRegistry so that
+// they are reported to the host.
+var (
+	compactionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "kv_file_compaction_duration_seconds",
+		Help: "Duration of data dir compactions.",
+	})
+
+	compactionRemoved = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kv_file_compaction_removed_files_total",
+		Help: "Orphaned content type files removed by compaction.",
+	})
+
+	keysDesc  = prometheus.NewDesc("kv_file_keys", "Keys in the data dir.", nil, nil)
+	bytesDesc = prometheus.NewDesc("kv_file_bytes", "Size of the value and content type files in the data dir.", nil, nil)
+)
+
+func registerMetrics(k *KV) {
+	shared.MetricsRegistry.MustRegister(compactionDuration, compactionRemoved, &diskCollector{kv: k})
+}
+
+// diskCollector counts the keys and bytes in the data dir on every gather.
+type diskCollector struct {
+	kv *KV
+}
+
+func (c *diskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keysDesc
+	ch <- bytesDesc
+}
+
+func (c *diskCollector) Collect(ch chan<- prometheus.Metric) {
+	entries, err := os.ReadDir(c.kv.config.keysDir())
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(keysDesc, err)
+		ch <- prometheus.NewInvalidMetric(bytesDesc, err)
+		return
+	}
+
+	keys, size := 0, int64(0)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "kv_") && !strings.HasPrefix(name, "kvmeta_") {
+			continue
+		}
+
+		if strings.HasPrefix(name, "kv_") {
+			keys++
+		}
+
+		info, err := entry.Info()
+		if err == nil {
+			size += info.Size()
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(keys))
+	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(size))
+}
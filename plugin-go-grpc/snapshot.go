@@ -0,0 +1,365 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+// snapshotMagic starts file backend snapshots. A record per key follows,
+// made of its key, content type and value, each prefixed with its length
+// as an uvarint.
+var snapshotMagic = []byte("KVFSNAP\x01")
+
+// maxSnapshotField bounds field lengths read from snapshots, so that a
+// corrupt length doesn't allocate gigabytes.
+const maxSnapshotField = 1 << 30
+
+// snapshot is a snapshot in progress. Writers save the state of keys it has
+// not copied yet before changing them, so that it sees the store as it was
+// when it started while writers only wait for the copy of a single key.
+type snapshot struct {
+	pending map[string]struct{} // keys not copied yet
+	saved   map[string]*entry   // state of pending keys before a write
+}
+
+// entry is the state of a key, nil when it doesn't exist.
+type entry struct {
+	value       []byte
+	contentType string
+}
+
+// Snapshot writes all keys as of the start of the call to w.
+func (k *KV) Snapshot(w io.Writer) error {
+	fmt.Fprintf(os.Stderr, "Plugin: got Snapshot() call.\n")
+
+	keys, err := k.startSnapshot()
+	if err != nil {
+		return err
+	}
+	defer k.endSnapshot()
+
+	bw := bufio.NewWriter(w)
+
+	_, err = bw.Write(snapshotMagic)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+
+	for _, key := range keys {
+		e, err := k.copyKey(key)
+		if err != nil {
+			return err
+		}
+
+		if e == nil {
+			continue
+		}
+
+		for _, field := range [][]byte{[]byte(key), []byte(e.contentType), e.value} {
+			err = writeField(bw, field)
+			if err != nil {
+				return err
+			}
+		}
+
+		count++
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	k.logClient.Log(int(shared.LogLevelInfo), fmt.Sprintf("Wrote snapshot of %d keys.", count))
+
+	return nil
+}
+
+func (k *KV) startSnapshot() ([]string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.snapshot != nil {
+		return nil, errors.New("a snapshot is already in progress")
+	}
+
+	keys, err := k.list("")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &snapshot{
+		pending: make(map[string]struct{}, len(keys)),
+		saved:   map[string]*entry{},
+	}
+
+	for _, key := range keys {
+		s.pending[key] = struct{}{}
+	}
+
+	k.snapshot = s
+
+	return keys, nil
+}
+
+func (k *KV) endSnapshot() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.snapshot = nil
+}
+
+// copyKey returns the state of key as of the start of the snapshot.
+func (k *KV) copyKey(key string) (*entry, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if e, ok := k.snapshot.saved[key]; ok {
+		delete(k.snapshot.saved, key)
+		return e, nil
+	}
+
+	delete(k.snapshot.pending, key)
+
+	return k.readEntry(key)
+}
+
+// preserve saves the state of key before a write if the snapshot in
+// progress has not copied it yet, the mutex must be held.
+func (k *KV) preserve(key string) error {
+	if k.snapshot == nil {
+		return nil
+	}
+
+	if _, pending := k.snapshot.pending[key]; !pending {
+		return nil
+	}
+
+	e, err := k.readEntry(key)
+	if err != nil {
+		return err
+	}
+
+	k.snapshot.saved[key] = e
+	delete(k.snapshot.pending, key)
+
+	return nil
+}
+
+func (k *KV) readEntry(key string) (*entry, error) {
+	value, contentType, err := k.get(key)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry{value: value, contentType: contentType}, nil
+}
+
+// Restore replaces all keys with those of a snapshot. The snapshot is
+// written to a staging generation directory first and only switched to
+// once it was read completely, lookups wait for the switch.
+func (k *KV) Restore(r io.Reader) error {
+	fmt.Fprintf(os.Stderr, "Plugin: got Restore() call.\n")
+
+	dir := k.config.generationsDir()
+
+	staged, err := os.MkdirTemp(dir, stagingPrefix)
+	if err != nil {
+		return err
+	}
+
+	switched := false
+	defer func() {
+		if !switched {
+			os.RemoveAll(staged)
+		}
+	}()
+
+	err = os.Chmod(staged, 0755)
+	if err != nil {
+		return err
+	}
+
+	keys, err := k.readSnapshot(r, staged)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	if k.config.Fsync == FsyncAlways {
+		err = syncFile(staged)
+		if err != nil {
+			return err
+		}
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.snapshot != nil {
+		return errors.New("cannot restore while a snapshot is in progress")
+	}
+
+	previous, err := os.Readlink(k.config.keysDir())
+	if err != nil {
+		return err
+	}
+
+	// the staging prefix keeps the generation from being switched to
+	// before it is complete
+	generation := generationPrefix + strings.TrimPrefix(filepath.Base(staged), stagingPrefix)
+
+	err = os.Rename(staged, filepath.Join(dir, generation))
+	if err != nil {
+		return err
+	}
+
+	staged = filepath.Join(dir, generation)
+
+	err = switchGeneration(dir, generation)
+	if err != nil {
+		return fmt.Errorf("restore failed, the previous state is kept: %w", err)
+	}
+
+	if k.config.Fsync == FsyncAlways {
+		err = syncFile(dir)
+		if err != nil {
+			// keep the restored state only if it is durable
+			rollbackErr := switchGeneration(dir, previous)
+			if rollbackErr != nil {
+				switched = true
+				return fmt.Errorf("restore failed, switching back to the previous state failed too: %w", errors.Join(err, rollbackErr))
+			}
+
+			return fmt.Errorf("restore failed, the previous state is kept: %w", err)
+		}
+	}
+
+	switched = true
+
+	// files of the previous generation are gone with it
+	k.dirty = map[string]struct{}{}
+
+	for _, key := range keys {
+		if k.config.Fsync == FsyncClose {
+			k.dirty[key] = struct{}{}
+		}
+	}
+
+	err = retireGeneration(dir, filepath.Base(previous))
+	if err != nil {
+		k.logClient.Log(int(shared.LogLevelWarn), fmt.Sprintf("Failed to remove previous generation %s: %v.", previous, err))
+	}
+
+	k.logClient.Log(int(shared.LogLevelInfo), fmt.Sprintf("Restored snapshot of %d keys.", len(keys)))
+
+	return nil
+}
+
+// readSnapshot writes the keys of a snapshot as files to dir.
+func (k *KV) readSnapshot(r io.Reader, dir string) ([]string, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(snapshotMagic))
+
+	_, err := io.ReadFull(br, magic)
+	if err != nil || !bytes.Equal(magic, snapshotMagic) {
+		return nil, errors.New("not a file backend snapshot")
+	}
+
+	keys := []string{}
+
+	for {
+		key, err := readField(br)
+		if errors.Is(err, io.EOF) {
+			return keys, nil
+		}
+
+		var contentType, value []byte
+
+		if err == nil {
+			contentType, err = readField(br)
+		}
+
+		if err == nil {
+			value, err = readField(br)
+		}
+
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err == nil {
+			// keys reach file names, as remote keys do
+			err = k.config.checkKey(string(key))
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(keys)+1, err)
+		}
+
+		name := k.config.encodeKey(string(key))
+
+		err = k.writeFile(filepath.Join(dir, "kv_"+name), value)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(contentType) > 0 {
+			err = k.writeFile(filepath.Join(dir, "kvmeta_"+name), contentType)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		keys = append(keys, string(key))
+	}
+}
+
+func writeField(w io.Writer, field []byte) error {
+	_, err := w.Write(binary.AppendUvarint(nil, uint64(len(field))))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(field)
+
+	return err
+}
+
+func readField(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > maxSnapshotField {
+		return nil, fmt.Errorf("invalid field length %d", size)
+	}
+
+	field := make([]byte, size)
+
+	_, err = io.ReadFull(r, field)
+	if err != nil {
+		return nil, err
+	}
+
+	return field, nil
+}
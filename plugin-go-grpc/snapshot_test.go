@@ -0,0 +1,172 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testSnapshot returns a snapshot made of magic followed by fields.
+func testSnapshot(t *testing.T, magic []byte, fields ...string) []byte {
+	t.Helper()
+
+	buf := bytes.NewBuffer(append([]byte{}, magic...))
+
+	for _, field := range fields {
+		err := writeField(buf, []byte(field))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestRestore(t *testing.T) {
+	valid := testSnapshot(t, snapshotMagic, "b", "text/plain", "2", "c", "", "3")
+
+	tests := []struct {
+		name     string
+		snapshot []byte
+		want     map[string]string // values after the restore
+		wantErr  bool
+	}{
+		{
+			name:     "replaces keys",
+			snapshot: valid,
+			want:     map[string]string{"b": "2", "c": "3"},
+		},
+		{
+			name:     "empty snapshot",
+			snapshot: snapshotMagic,
+			want:     map[string]string{},
+		},
+		{
+			name:     "not a snapshot",
+			snapshot: []byte("KVFSNAP\x02"),
+			want:     map[string]string{"a": "1"},
+			wantErr:  true,
+		},
+		{
+			name:     "truncated record",
+			snapshot: valid[:len(valid)-1],
+			want:     map[string]string{"a": "1"},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid key",
+			snapshot: testSnapshot(t, snapshotMagic, "b", "", "2", "../escape", "", "3"),
+			want:     map[string]string{"a": "1"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			k := newTestKV(t, t.TempDir())
+
+			err := k.Put("a", []byte("1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			previous, err := os.Readlink(k.config.keysDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = k.Restore(bytes.NewReader(tc.snapshot))
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+
+			current, err := os.Readlink(k.config.keysDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the replaced generation is removed, a failed restore
+			// leaves neither a staging generation nor a new one
+			wantGens := []string{"current", current}
+			if tc.wantErr && current != previous {
+				t.Errorf("current generation is %s, want %s", current, previous)
+			}
+			if !tc.wantErr && current == previous {
+				t.Errorf("current generation is still %s", previous)
+			}
+
+			if got := dirNames(t, k.config.generationsDir()); !reflect.DeepEqual(got, wantGens) {
+				t.Errorf("generations dir has %v, want %v", got, wantGens)
+			}
+
+			keys, err := k.List("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(keys) != len(tc.want) {
+				t.Errorf("got keys %v, want %d keys", keys, len(tc.want))
+			}
+
+			for key, want := range tc.want {
+				value, err := k.Get(key)
+				if err != nil || string(value) != want {
+					t.Errorf("%s is %q (%v), want %q", key, value, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshotRestoreRoundTrip(t *testing.T) {
+	from := newTestKV(t, t.TempDir())
+
+	for _, key := range []string{"a", "b", "c"} {
+		err := from.PutWithContentType(key, []byte(strings.Repeat(key, 3)), "text/"+key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var snapshot bytes.Buffer
+
+	err := from.Snapshot(&snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := newTestKV(t, t.TempDir())
+
+	err = to.Restore(bytes.NewReader(snapshot.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		value, contentType, err := to.GetWithContentType(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(value) != strings.Repeat(key, 3) || contentType != "text/"+key {
+			t.Errorf("%s is %q of type %q", key, value, contentType)
+		}
+	}
+
+	// restoring replaces the generation again
+	err = to.Restore(bytes.NewReader(snapshotMagic))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = to.Get("a")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+const (
+	EnvPluginLockFile     = "KV_PLUGIN_LOCKFILE"
+	DefaultPluginLockFile = "plugins.lock"
+
+	// EnvPluginUnverified allows launching plugins without a lockfile.
+	EnvPluginUnverified = "KV_PLUGIN_UNVERIFIED"
+)
+
+// PluginLock holds pinned SHA-256 checksums of plugin binaries. The lockfile
+// uses sha256sum output format, one "<hex checksum>  <path>" entry per line,
+// so it can be produced with `sha256sum kv-go-grpc > plugins.lock`. Relative
+// paths are relative to the directory of the lockfile.
+type PluginLock struct {
+	path      string
+	checksums map[string][]byte
+}
+
+// LoadPluginLockFromEnv loads the lockfile named by KV_PLUGIN_LOCKFILE, or
+// plugins.lock if it exists. It returns nil when no lockfile is configured.
+func LoadPluginLockFromEnv() (*PluginLock, error) {
+	path, explicit := os.LookupEnv(EnvPluginLockFile)
+	if !explicit {
+		path = DefaultPluginLockFile
+	}
+
+	lock, err := LoadPluginLock(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil, nil
+	}
+
+	return lock, err
+}
+
+// UnverifiedPluginsAllowed reports whether KV_PLUGIN_UNVERIFIED opts out of
+// plugin pinning when there is no lockfile.
+func UnverifiedPluginsAllowed() bool {
+	allowed, _ := strconv.ParseBool(os.Getenv(EnvPluginUnverified))
+	return allowed
+}
+
+func LoadPluginLock(path string) (*PluginLock, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plugin lockfile: %w", err)
+	}
+	defer file.Close()
+
+	lock := &PluginLock{
+		path:      path,
+		checksums: map[string][]byte{},
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<sha256>  <path>\"", path, lineNum)
+		}
+
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid sha256 checksum %q", path, lineNum, fields[0])
+		}
+
+		// sha256sum marks binary mode files with a leading '*'
+		pluginPath := strings.TrimPrefix(fields[1], "*")
+		if !filepath.IsAbs(pluginPath) {
+			pluginPath = filepath.Join(dir, pluginPath)
+		}
+
+		lock.checksums[filepath.Clean(pluginPath)] = sum
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// Checksum returns the pinned checksum for a plugin path, relative paths
+// are relative to the working directory.
+func (l *PluginLock) Checksum(pluginPath string) ([]byte, error) {
+	absPath, err := filepath.Abs(pluginPath)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, ok := l.checksums[absPath]
+	if !ok {
+		return nil, fmt.Errorf("plugin %q is not pinned in %s", pluginPath, l.path)
+	}
+
+	return sum, nil
+}
+
+// Verify checks the plugin binary against its pinned checksum and returns
+// the actual checksum of the binary.
+func (l *PluginLock) Verify(pluginPath string) (string, error) {
+	expected, err := l.Checksum(pluginPath)
+	if err != nil {
+		return "", err
+	}
+
+	actual, err := FileChecksum(pluginPath)
+	if err != nil {
+		return "", err
+	}
+
+	if actual != hex.EncodeToString(expected) {
+		return actual, fmt.Errorf("checksum mismatch for plugin %q: expected %x, got %s", pluginPath, expected, actual)
+	}
+
+	return actual, nil
+}
+
+// VerifyChecksum checks a plugin binary against expected, it returns an
+// error wrapping plugin.ErrChecksumsDoNotMatch if they differ.
+func VerifyChecksum(pluginPath string, expected []byte) error {
+	actual, err := FileChecksum(pluginPath)
+	if err != nil {
+		return err
+	}
+
+	if actual != hex.EncodeToString(expected) {
+		return fmt.Errorf("%w: plugin %q: expected %x, got %s", plugin.ErrChecksumsDoNotMatch, pluginPath, expected, actual)
+	}
+
+	return nil
+}
+
+// FileChecksum returns the hex encoded SHA-256 checksum of a file.
+func FileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	zlog "github.com/rs/zerolog/log"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// pluginMetricPrefix is prepended to the names of metrics reported by
+// plugins, so that they don't collide with host metrics of the same name
+// such as the Go runtime ones.
+const pluginMetricPrefix = "plugin_"
+
+// PluginMetrics receives the metrics reported by a plugin over the broker
+// and collects them with a plugin label set to the store name.
+type PluginMetrics struct {
+	plugin   string
+	mutex    sync.Mutex
+	families []*dto.MetricFamily
+}
+
+func NewPluginMetrics(plugin string) *PluginMetrics {
+	return &PluginMetrics{plugin: plugin}
+}
+
+// Report replaces the previously reported metrics.
+func (m *PluginMetrics) Report(families []*dto.MetricFamily) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.families = families
+
+	return nil
+}
+
+// Describe sends no descriptors, which makes PluginMetrics an unchecked
+// collector, as plugin metrics are only known once reported.
+func (m *PluginMetrics) Describe(ch chan<- *prometheus.Desc) {}
+
+func (m *PluginMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.mutex.Lock()
+	families := m.families
+	m.mutex.Unlock()
+
+	for _, family := range families {
+		name := pluginMetricPrefix + family.GetName()
+
+		for _, metric := range family.GetMetric() {
+			labels := prometheus.Labels{}
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+
+			labels["plugin"] = m.plugin
+
+			ch <- &pluginMetric{
+				desc:   prometheus.NewDesc(name, family.GetHelp(), nil, labels),
+				metric: metric,
+				labels: labels,
+			}
+		}
+	}
+}
+
+// pluginMetric is a reported metric with the plugin label added.
+type pluginMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+	labels prometheus.Labels
+}
+
+func (m *pluginMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m *pluginMetric) Write(out *dto.Metric) error {
+	names := make([]string, 0, len(m.labels))
+	for name := range m.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out.Label = make([]*dto.LabelPair, 0, len(names))
+	for _, name := range names {
+		out.Label = append(out.Label, &dto.LabelPair{
+			Name:  protobuf.String(name),
+			Value: protobuf.String(m.labels[name]),
+		})
+	}
+
+	out.Counter = m.metric.Counter
+	out.Gauge = m.metric.Gauge
+	out.Histogram = m.metric.Histogram
+	out.Summary = m.metric.Summary
+	out.Untyped = m.metric.Untyped
+	out.TimestampMs = m.metric.TimestampMs
+
+	return nil
+}
+
+// metricsErrorLog logs errors of the /metrics handler, e.g. invalid
+// plugin metrics that are left out.
+type metricsErrorLog struct{}
+
+func (metricsErrorLog) Println(v ...interface{}) {
+	zlog.Warn().Msg(fmt.Sprint(v...))
+}
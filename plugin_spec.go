@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+const (
+	EnvPluginPath         = "KV_PLUGIN"
+	EnvPluginArgs         = "KV_PLUGIN_ARGS"
+	EnvPluginEnv          = "KV_PLUGIN_ENV"
+	EnvPluginDir          = "KV_PLUGIN_DIR"
+	EnvPluginMaxMemory    = "KV_PLUGIN_MAX_MEMORY"
+	EnvPluginMaxOpenFiles = "KV_PLUGIN_MAX_OPEN_FILES"
+)
+
+// DefaultPluginEnv is the list of host environment variables passed to
+// every plugin, in addition to the ones listed in PluginSpec.Env.
+var DefaultPluginEnv = []string{"PATH", "TMPDIR"}
+
+// ResourceLimits holds optional rlimits applied to the plugin process.
+// Zero values mean "leave unchanged".
+type ResourceLimits struct {
+	MaxMemoryBytes uint64 // RLIMIT_AS
+	MaxOpenFiles   uint64 // RLIMIT_NOFILE
+}
+
+// PluginSpec describes how to launch a plugin process: the binary is
+// executed directly (no shell), so go-plugin tracks the real plugin PID.
+type PluginSpec struct {
+	Path   string
+	Args   []string
+	Env    []string // names of host env vars the plugin may see
+	Dir    string
+	Limits ResourceLimits
+}
+
+// PluginSpecFromEnv builds a PluginSpec from KV_PLUGIN* environment variables.
+func PluginSpecFromEnv() (*PluginSpec, error) {
+	return pluginSpecFromEnv(os.Getenv)
+}
+
+func pluginSpecFromEnv(getenv func(string) string) (*PluginSpec, error) {
+	spec := &PluginSpec{
+		Path: getenv(EnvPluginPath),
+		Dir:  getenv(EnvPluginDir),
+	}
+
+	var err error
+	spec.Args, err = parseList(EnvPluginArgs, getenv(EnvPluginArgs), strings.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	spec.Env, err = parseList(EnvPluginEnv, getenv(EnvPluginEnv), splitList)
+	if err != nil {
+		return nil, err
+	}
+
+	spec.Limits.MaxMemoryBytes, err = parseLimit(EnvPluginMaxMemory, getenv(EnvPluginMaxMemory))
+	if err != nil {
+		return nil, err
+	}
+
+	spec.Limits.MaxOpenFiles, err = parseLimit(EnvPluginMaxOpenFiles, getenv(EnvPluginMaxOpenFiles))
+	if err != nil {
+		return nil, err
+	}
+
+	return spec, spec.Validate()
+}
+
+func (s *PluginSpec) Validate() error {
+	if s.Path == "" {
+		return fmt.Errorf("plugin path is not set, use %s", EnvPluginPath)
+	}
+
+	return nil
+}
+
+// Command returns an unstarted command for the plugin. Its environment
+// contains only allowlisted host variables; go-plugin appends its own
+// handshake variables when the client is configured with SkipHostEnv.
+// Plugins with resource limits or a checksum are started through the
+// launcher, which verifies checksum if it is not nil.
+func (s *PluginSpec) Command(checksum []byte) (*exec.Cmd, error) {
+	if !s.viaLauncher(checksum) {
+		// the binary go-plugin verifies against SecureConfig is cmd.Path,
+		// resolved here once rather than against Dir by exec and against
+		// the host working directory by go-plugin
+		cmd := exec.Command(s.BinaryPath(), s.Args...)
+		cmd.Dir = s.Dir
+		cmd.Env = s.environ()
+
+		return cmd, nil
+	}
+
+	cmd, err := launcherCommand(launcherSpec{
+		Path:   s.BinaryPath(),
+		Limits: s.Limits,
+		SHA256: hex.EncodeToString(checksum),
+	}, s.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Dir = s.Dir
+	cmd.Env = append(s.environ(), cmd.Env...)
+
+	return cmd, nil
+}
+
+// SecureConfig returns the go-plugin config refusing to start the plugin
+// binary unless it matches checksum, nil if there is no checksum or the
+// launcher verifies it.
+func (s *PluginSpec) SecureConfig(checksum []byte) *plugin.SecureConfig {
+	if checksum == nil || s.viaLauncher(checksum) {
+		return nil
+	}
+
+	return &plugin.SecureConfig{
+		Checksum: checksum,
+		Hash:     sha256.New(),
+	}
+}
+
+// BinaryPath returns the absolute path of the plugin binary: like exec, it
+// looks up bare names in PATH and evaluates relative paths against Dir.
+func (s *PluginSpec) BinaryPath() string {
+	path := s.Path
+
+	if !strings.ContainsRune(path, filepath.Separator) {
+		if found, err := exec.LookPath(path); err == nil {
+			path = found
+		}
+	} else if !filepath.IsAbs(path) {
+		path = filepath.Join(s.Dir, path)
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	return path
+}
+
+// viaLauncher reports whether the plugin is started by the launcher, which
+// is needed for resource limits and, where supported, verifies the pinned
+// checksum on the very file it executes.
+func (s *PluginSpec) viaLauncher(checksum []byte) bool {
+	return s.Limits != ResourceLimits{} || checksum != nil && launcherSupported
+}
+
+func (s *PluginSpec) environ() []string {
+	env := []string{}
+
+	for _, name := range append(DefaultPluginEnv, s.Env...) {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	return env
+}
+
+func parseLimit(envName string, str string) (uint64, error) {
+	if str == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q: %v", envName, str, err)
+	}
+
+	return limit, nil
+}
+
+// parseList parses a JSON array of strings, as the config file settings are
+// passed, or a list split with split otherwise.
+func parseList(envName string, str string, split func(string) []string) ([]string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(str), "[") {
+		return split(str), nil
+	}
+
+	list := []string{}
+
+	err := json.Unmarshal([]byte(str), &list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", envName, str, err)
+	}
+
+	return list, nil
+}
+
+// encodeList encodes list for parseList, empty if list is.
+func encodeList(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	data, _ := json.Marshal(list) // strings always encode
+	return string(data)
+}
+
+func splitList(str string) []string {
+	list := []string{}
+
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -22,10 +22,18 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	KV_Ping_FullMethodName = "/proto.KV/Ping"
-	KV_Init_FullMethodName = "/proto.KV/Init"
-	KV_Get_FullMethodName  = "/proto.KV/Get"
-	KV_Put_FullMethodName  = "/proto.KV/Put"
+	KV_Ping_FullMethodName         = "/proto.KV/Ping"
+	KV_Init_FullMethodName         = "/proto.KV/Init"
+	KV_Get_FullMethodName          = "/proto.KV/Get"
+	KV_Put_FullMethodName          = "/proto.KV/Put"
+	KV_Delete_FullMethodName       = "/proto.KV/Delete"
+	KV_Capabilities_FullMethodName = "/proto.KV/Capabilities"
+	KV_Shutdown_FullMethodName     = "/proto.KV/Shutdown"
+	KV_Health_FullMethodName       = "/proto.KV/Health"
+	KV_List_FullMethodName         = "/proto.KV/List"
+	KV_SetLogLevel_FullMethodName  = "/proto.KV/SetLogLevel"
+	KV_Snapshot_FullMethodName     = "/proto.KV/Snapshot"
+	KV_Restore_FullMethodName      = "/proto.KV/Restore"
 )
 
 // KVClient is the client API for KV service.
@@ -36,6 +44,15 @@ type KVClient interface {
 	Init(ctx context.Context, in *InitRequest, opts ...grpc.CallOption) (*Empty, error)
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*Empty, error)
+	// protocol version 2
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*Empty, error)
+	Capabilities(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CapabilitiesResponse, error)
+	Shutdown(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
+	Health(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*HealthResponse, error)
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	SetLogLevel(ctx context.Context, in *SetLogLevelRequest, opts ...grpc.CallOption) (*Empty, error)
+	Snapshot(ctx context.Context, in *Empty, opts ...grpc.CallOption) (KV_SnapshotClient, error)
+	Restore(ctx context.Context, opts ...grpc.CallOption) (KV_RestoreClient, error)
 }
 
 type kVClient struct {
@@ -82,6 +99,126 @@ func (c *kVClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOpt
 	return out, nil
 }
 
+func (c *kVClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, KV_Delete_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVClient) Capabilities(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CapabilitiesResponse, error) {
+	out := new(CapabilitiesResponse)
+	err := c.cc.Invoke(ctx, KV_Capabilities_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVClient) Shutdown(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, KV_Shutdown_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVClient) Health(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*HealthResponse, error) {
+	out := new(HealthResponse)
+	err := c.cc.Invoke(ctx, KV_Health_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, KV_List_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVClient) SetLogLevel(ctx context.Context, in *SetLogLevelRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, KV_SetLogLevel_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kVClient) Snapshot(ctx context.Context, in *Empty, opts ...grpc.CallOption) (KV_SnapshotClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KV_ServiceDesc.Streams[0], KV_Snapshot_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVSnapshotClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KV_SnapshotClient interface {
+	Recv() (*SnapshotChunk, error)
+	grpc.ClientStream
+}
+
+type kVSnapshotClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVSnapshotClient) Recv() (*SnapshotChunk, error) {
+	m := new(SnapshotChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *kVClient) Restore(ctx context.Context, opts ...grpc.CallOption) (KV_RestoreClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KV_ServiceDesc.Streams[1], KV_Restore_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &kVRestoreClient{stream}
+	return x, nil
+}
+
+type KV_RestoreClient interface {
+	Send(*SnapshotChunk) error
+	CloseAndRecv() (*Empty, error)
+	grpc.ClientStream
+}
+
+type kVRestoreClient struct {
+	grpc.ClientStream
+}
+
+func (x *kVRestoreClient) Send(m *SnapshotChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *kVRestoreClient) CloseAndRecv() (*Empty, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Empty)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // KVServer is the server API for KV service.
 // All implementations must embed UnimplementedKVServer
 // for forward compatibility
@@ -90,6 +227,15 @@ type KVServer interface {
 	Init(context.Context, *InitRequest) (*Empty, error)
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Put(context.Context, *PutRequest) (*Empty, error)
+	// protocol version 2
+	Delete(context.Context, *DeleteRequest) (*Empty, error)
+	Capabilities(context.Context, *Empty) (*CapabilitiesResponse, error)
+	Shutdown(context.Context, *Empty) (*Empty, error)
+	Health(context.Context, *Empty) (*HealthResponse, error)
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	SetLogLevel(context.Context, *SetLogLevelRequest) (*Empty, error)
+	Snapshot(*Empty, KV_SnapshotServer) error
+	Restore(KV_RestoreServer) error
 	mustEmbedUnimplementedKVServer()
 }
 
@@ -109,6 +255,30 @@ func (UnimplementedKVServer) Get(context.Context, *GetRequest) (*GetResponse, er
 func (UnimplementedKVServer) Put(context.Context, *PutRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
 }
+func (UnimplementedKVServer) Delete(context.Context, *DeleteRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedKVServer) Capabilities(context.Context, *Empty) (*CapabilitiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Capabilities not implemented")
+}
+func (UnimplementedKVServer) Shutdown(context.Context, *Empty) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Shutdown not implemented")
+}
+func (UnimplementedKVServer) Health(context.Context, *Empty) (*HealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Health not implemented")
+}
+func (UnimplementedKVServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedKVServer) SetLogLevel(context.Context, *SetLogLevelRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
+}
+func (UnimplementedKVServer) Snapshot(*Empty, KV_SnapshotServer) error {
+	return status.Errorf(codes.Unimplemented, "method Snapshot not implemented")
+}
+func (UnimplementedKVServer) Restore(KV_RestoreServer) error {
+	return status.Errorf(codes.Unimplemented, "method Restore not implemented")
+}
 func (UnimplementedKVServer) mustEmbedUnimplementedKVServer() {}
 
 // UnsafeKVServer may be embedded to opt out of forward compatibility for this service.
@@ -194,6 +364,161 @@ func _KV_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{})
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KV_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KV_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KV_Capabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVServer).Capabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KV_Capabilities_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVServer).Capabilities(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KV_Shutdown_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVServer).Shutdown(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KV_Shutdown_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVServer).Shutdown(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KV_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KV_Health_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVServer).Health(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KV_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KV_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KV_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetLogLevelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVServer).SetLogLevel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KV_SetLogLevel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVServer).SetLogLevel(ctx, req.(*SetLogLevelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KV_Snapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KVServer).Snapshot(m, &kVSnapshotServer{stream})
+}
+
+type KV_SnapshotServer interface {
+	Send(*SnapshotChunk) error
+	grpc.ServerStream
+}
+
+type kVSnapshotServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVSnapshotServer) Send(m *SnapshotChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KV_Restore_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KVServer).Restore(&kVRestoreServer{stream})
+}
+
+type KV_RestoreServer interface {
+	SendAndClose(*Empty) error
+	Recv() (*SnapshotChunk, error)
+	grpc.ServerStream
+}
+
+type kVRestoreServer struct {
+	grpc.ServerStream
+}
+
+func (x *kVRestoreServer) SendAndClose(m *Empty) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *kVRestoreServer) Recv() (*SnapshotChunk, error) {
+	m := new(SnapshotChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // KV_ServiceDesc is the grpc.ServiceDesc for KV service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -217,8 +542,43 @@ var KV_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Put",
 			Handler:    _KV_Put_Handler,
 		},
+		{
+			MethodName: "Delete",
+			Handler:    _KV_Delete_Handler,
+		},
+		{
+			MethodName: "Capabilities",
+			Handler:    _KV_Capabilities_Handler,
+		},
+		{
+			MethodName: "Shutdown",
+			Handler:    _KV_Shutdown_Handler,
+		},
+		{
+			MethodName: "Health",
+			Handler:    _KV_Health_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _KV_List_Handler,
+		},
+		{
+			MethodName: "SetLogLevel",
+			Handler:    _KV_SetLogLevel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Snapshot",
+			Handler:       _KV_Snapshot_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Restore",
+			Handler:       _KV_Restore_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "kv.proto",
 }
 
@@ -311,3 +671,248 @@ var LogHelper_ServiceDesc = grpc.ServiceDesc{
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "kv.proto",
 }
+
+const (
+	HostConfig_Get_FullMethodName   = "/proto.HostConfig/Get"
+	HostConfig_Watch_FullMethodName = "/proto.HostConfig/Watch"
+)
+
+// HostConfigClient is the client API for HostConfig service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type HostConfigClient interface {
+	Get(ctx context.Context, in *HostConfigGetRequest, opts ...grpc.CallOption) (*HostConfigValue, error)
+	Watch(ctx context.Context, in *HostConfigWatchRequest, opts ...grpc.CallOption) (HostConfig_WatchClient, error)
+}
+
+type hostConfigClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHostConfigClient(cc grpc.ClientConnInterface) HostConfigClient {
+	return &hostConfigClient{cc}
+}
+
+func (c *hostConfigClient) Get(ctx context.Context, in *HostConfigGetRequest, opts ...grpc.CallOption) (*HostConfigValue, error) {
+	out := new(HostConfigValue)
+	err := c.cc.Invoke(ctx, HostConfig_Get_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hostConfigClient) Watch(ctx context.Context, in *HostConfigWatchRequest, opts ...grpc.CallOption) (HostConfig_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &HostConfig_ServiceDesc.Streams[0], HostConfig_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hostConfigWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type HostConfig_WatchClient interface {
+	Recv() (*HostConfigValue, error)
+	grpc.ClientStream
+}
+
+type hostConfigWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *hostConfigWatchClient) Recv() (*HostConfigValue, error) {
+	m := new(HostConfigValue)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// HostConfigServer is the server API for HostConfig service.
+// All implementations must embed UnimplementedHostConfigServer
+// for forward compatibility
+type HostConfigServer interface {
+	Get(context.Context, *HostConfigGetRequest) (*HostConfigValue, error)
+	Watch(*HostConfigWatchRequest, HostConfig_WatchServer) error
+	mustEmbedUnimplementedHostConfigServer()
+}
+
+// UnimplementedHostConfigServer must be embedded to have forward compatible implementations.
+type UnimplementedHostConfigServer struct {
+}
+
+func (UnimplementedHostConfigServer) Get(context.Context, *HostConfigGetRequest) (*HostConfigValue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedHostConfigServer) Watch(*HostConfigWatchRequest, HostConfig_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedHostConfigServer) mustEmbedUnimplementedHostConfigServer() {}
+
+// UnsafeHostConfigServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HostConfigServer will
+// result in compilation errors.
+type UnsafeHostConfigServer interface {
+	mustEmbedUnimplementedHostConfigServer()
+}
+
+func RegisterHostConfigServer(s grpc.ServiceRegistrar, srv HostConfigServer) {
+	s.RegisterService(&HostConfig_ServiceDesc, srv)
+}
+
+func _HostConfig_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HostConfigGetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HostConfigServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HostConfig_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HostConfigServer).Get(ctx, req.(*HostConfigGetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HostConfig_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(HostConfigWatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(HostConfigServer).Watch(m, &hostConfigWatchServer{stream})
+}
+
+type HostConfig_WatchServer interface {
+	Send(*HostConfigValue) error
+	grpc.ServerStream
+}
+
+type hostConfigWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *hostConfigWatchServer) Send(m *HostConfigValue) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// HostConfig_ServiceDesc is the grpc.ServiceDesc for HostConfig service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var HostConfig_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.HostConfig",
+	HandlerType: (*HostConfigServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _HostConfig_Get_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _HostConfig_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "kv.proto",
+}
+
+const (
+	Metrics_Report_FullMethodName = "/proto.Metrics/Report"
+)
+
+// MetricsClient is the client API for Metrics service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MetricsClient interface {
+	Report(ctx context.Context, in *MetricsReportRequest, opts ...grpc.CallOption) (*Empty, error)
+}
+
+type metricsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMetricsClient(cc grpc.ClientConnInterface) MetricsClient {
+	return &metricsClient{cc}
+}
+
+func (c *metricsClient) Report(ctx context.Context, in *MetricsReportRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Metrics_Report_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MetricsServer is the server API for Metrics service.
+// All implementations must embed UnimplementedMetricsServer
+// for forward compatibility
+type MetricsServer interface {
+	Report(context.Context, *MetricsReportRequest) (*Empty, error)
+	mustEmbedUnimplementedMetricsServer()
+}
+
+// UnimplementedMetricsServer must be embedded to have forward compatible implementations.
+type UnimplementedMetricsServer struct {
+}
+
+func (UnimplementedMetricsServer) Report(context.Context, *MetricsReportRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Report not implemented")
+}
+func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
+
+// UnsafeMetricsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MetricsServer will
+// result in compilation errors.
+type UnsafeMetricsServer interface {
+	mustEmbedUnimplementedMetricsServer()
+}
+
+func RegisterMetricsServer(s grpc.ServiceRegistrar, srv MetricsServer) {
+	s.RegisterService(&Metrics_ServiceDesc, srv)
+}
+
+func _Metrics_Report_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MetricsReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).Report(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_Report_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).Report(ctx, req.(*MetricsReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Metrics_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Metrics",
+	HandlerType: (*MetricsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Report",
+			Handler:    _Metrics_Report_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "kv.proto",
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/shared"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	restKeysPath = "/v1/keys"
+
+	requestIDHeader = "X-Request-ID"
+
+	// defaultMaxBodySize limits PUT bodies when the plugin reports no
+	// maximum value size.
+	defaultMaxBodySize = 64 << 20
+)
+
+// RESTGateway exposes the KV store as a HTTP/JSON API:
+//
+//	GET    /v1/keys/{key}
+//	PUT    /v1/keys/{key}
+//	DELETE /v1/keys/{key}
+//	GET    /v1/keys?prefix=
+type RESTGateway struct {
+	kv *Supervisor
+}
+
+func NewRESTGateway(kv *Supervisor) *RESTGateway {
+	return &RESTGateway{kv: kv}
+}
+
+func (g *RESTGateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(restKeysPath, g.handleList)
+	mux.HandleFunc(restKeysPath+"/", g.handleKey)
+
+	return traceRequests(tagRequests(mux))
+}
+
+// tagRequests gives every request the ID found in its X-Request-ID header,
+// or a new one if it has none or an invalid one, and returns it in the
+// response header.
+func tagRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if requestID := r.Header.Get(requestIDHeader); shared.ValidRequestID(requestID) {
+			ctx = shared.ContextWithRequestID(ctx, requestID)
+		}
+
+		ctx, requestID := shared.WithRequestID(ctx)
+		w.Header().Set(requestIDHeader, requestID)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// traceRequests runs every request in a server span, child of the trace
+// context found in its traceparent header if any.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		// keys are left out of span names, they are unbounded
+		route := restKeysPath
+		if strings.HasPrefix(r.URL.Path, restKeysPath+"/") {
+			route += "/{key}"
+		}
+
+		ctx, span := shared.Tracer().Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (g *RESTGateway) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeRESTError(w, r, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	keys, err := g.kv.ListContext(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		writeRESTError(w, r, restStatusCode(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]string{"keys": keys})
+}
+
+func (g *RESTGateway) handleKey(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, restKeysPath+"/")
+	if key == "" {
+		writeRESTError(w, r, http.StatusBadRequest, errors.New("empty key"))
+		return
+	}
+
+	// keys rejected by the gRPC service are rejected here the same way
+	err := shared.ValidateKey(key)
+	if err != nil {
+		writeRESTError(w, r, restStatusCode(err), err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		g.get(w, r, key)
+	case http.MethodPut:
+		g.put(w, r, key)
+	case http.MethodDelete:
+		g.delete(w, r, key)
+	default:
+		writeRESTError(w, r, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (g *RESTGateway) get(w http.ResponseWriter, r *http.Request, key string) {
+	value, contentType, err := g.kv.GetContext(r.Context(), key)
+	if err != nil {
+		writeRESTError(w, r, restStatusCode(err), err)
+		return
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(value)
+}
+
+func (g *RESTGateway) put(w http.ResponseWriter, r *http.Request, key string) {
+	caps, err := g.kv.Capabilities()
+	if err != nil {
+		writeRESTError(w, r, restStatusCode(err), err)
+		return
+	}
+
+	maxSize := int64(defaultMaxBodySize)
+	if caps.MaxValueSize > 0 {
+		maxSize = int64(caps.MaxValueSize)
+	}
+
+	// read one byte more than allowed so that too large bodies are detected
+	value, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	if err != nil {
+		writeRESTError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if int64(len(value)) > maxSize {
+		writeRESTError(w, r, http.StatusRequestEntityTooLarge, shared.ErrValueTooLarge)
+		return
+	}
+
+	err = g.kv.PutContext(r.Context(), key, value, r.Header.Get("Content-Type"))
+	if err != nil {
+		writeRESTError(w, r, restStatusCode(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *RESTGateway) delete(w http.ResponseWriter, r *http.Request, key string) {
+	err := g.kv.DeleteContext(r.Context(), key)
+	if err != nil {
+		writeRESTError(w, r, restStatusCode(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restStatusCode maps typed errors to HTTP status codes.
+func restStatusCode(err error) int {
+	switch {
+	case errors.Is(err, shared.ErrInvalidArgument):
+		return http.StatusBadRequest
+	case errors.Is(err, shared.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, shared.ErrUnsupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, shared.ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrSupervisorStopped), errors.Is(err, ErrSupervisorFailed), status.Code(err) == codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeRESTError(w http.ResponseWriter, r *http.Request, code int, err error) {
+	if code == http.StatusInternalServerError {
+		zerolog.Ctx(r.Context()).Error().Err(err).Msg("REST request failed.")
+	}
+
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to write REST response.")
+	}
+}
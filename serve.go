@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/proto"
+	"github.com/tinybit/go-plugin-log-example/shared"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	EnvListen     = "KV_LISTEN"
+	DefaultListen = "tcp://127.0.0.1:7070"
+
+	// TLS of the serve listeners, required on non-loopback addresses. With
+	// a CA, clients must present a certificate signed by it.
+	EnvServeTLSCert = "KV_SERVE_TLS_CERT"
+	EnvServeTLSKey  = "KV_SERVE_TLS_KEY"
+	EnvServeTLSCA   = "KV_SERVE_TLS_CA"
+
+	gracefulStopTimeout = 10 * time.Second
+
+	metricsPath = "/metrics"
+)
+
+// KVService re-exposes the plugin KV store to remote gRPC clients. Init and
+// Shutdown are plugin lifecycle calls owned by the host and stay
+// unimplemented. Request IDs sent by clients in x-request-id metadata are
+// passed on to the plugin.
+type KVService struct {
+	proto.UnimplementedKVServer
+	kv     *Supervisor
+	health *HealthMonitor
+}
+
+func NewKVService(kv *Supervisor, health *HealthMonitor) *KVService {
+	return &KVService{
+		kv:     kv,
+		health: health,
+	}
+}
+
+func (m *KVService) Ping(ctx context.Context, req *proto.Empty) (*proto.Empty, error) {
+	ctx = shared.IncomingRequestContext(ctx)
+
+	client, err := m.kv.Client()
+	if err != nil {
+		return nil, callFailed(ctx, "Ping", err)
+	}
+
+	err = client.Ping()
+	if err != nil {
+		return nil, callFailed(ctx, "Ping", err)
+	}
+
+	return &proto.Empty{}, nil
+}
+
+func (m *KVService) Get(ctx context.Context, req *proto.GetRequest) (*proto.GetResponse, error) {
+	ctx = shared.IncomingRequestContext(ctx)
+
+	err := shared.ValidateKey(req.Key)
+	if err != nil {
+		return nil, callFailed(ctx, "Get", err)
+	}
+
+	v, contentType, err := m.kv.GetContext(ctx, req.Key)
+	if err != nil {
+		return nil, callFailed(ctx, "Get", err)
+	}
+
+	return &proto.GetResponse{Value: v, ContentType: contentType}, nil
+}
+
+func (m *KVService) Put(ctx context.Context, req *proto.PutRequest) (*proto.Empty, error) {
+	ctx = shared.IncomingRequestContext(ctx)
+
+	err := shared.ValidateKey(req.Key)
+	if err != nil {
+		return nil, callFailed(ctx, "Put", err)
+	}
+
+	err = m.kv.PutContext(ctx, req.Key, req.Value, req.ContentType)
+	if err != nil {
+		return nil, callFailed(ctx, "Put", err)
+	}
+
+	return &proto.Empty{}, nil
+}
+
+func (m *KVService) Delete(ctx context.Context, req *proto.DeleteRequest) (*proto.Empty, error) {
+	ctx = shared.IncomingRequestContext(ctx)
+
+	err := shared.ValidateKey(req.Key)
+	if err != nil {
+		return nil, callFailed(ctx, "Delete", err)
+	}
+
+	err = m.kv.DeleteContext(ctx, req.Key)
+	if err != nil {
+		return nil, callFailed(ctx, "Delete", err)
+	}
+
+	return &proto.Empty{}, nil
+}
+
+func (m *KVService) List(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error) {
+	ctx = shared.IncomingRequestContext(ctx)
+
+	keys, err := m.kv.ListContext(ctx, req.Prefix)
+	if err != nil {
+		return nil, callFailed(ctx, "List", err)
+	}
+
+	return &proto.ListResponse{Keys: keys}, nil
+}
+
+func (m *KVService) Capabilities(ctx context.Context, req *proto.Empty) (*proto.CapabilitiesResponse, error) {
+	ctx = shared.IncomingRequestContext(ctx)
+
+	caps, err := m.kv.Capabilities()
+	if err != nil {
+		return nil, callFailed(ctx, "Capabilities", err)
+	}
+
+	return shared.CapabilitiesToProto(caps), nil
+}
+
+// Health reports the host view of the plugin health.
+func (m *KVService) Health(ctx context.Context, req *proto.Empty) (*proto.HealthResponse, error) {
+	report := m.health.Status()
+
+	resp := &proto.HealthResponse{
+		Status:  proto.HealthStatus_HEALTH_STATUS_OK,
+		Reasons: report.Reasons,
+	}
+
+	switch report.State {
+	case HealthReady:
+	case HealthStarting, HealthDegraded:
+		resp.Status = proto.HealthStatus_HEALTH_STATUS_DEGRADED
+	default:
+		resp.Status = proto.HealthStatus_HEALTH_STATUS_UNHEALTHY
+	}
+
+	return resp, nil
+}
+
+// callFailed logs the failure of the KVService call method with the logger
+// of ctx, tagged with its request ID, and returns err as a gRPC status error.
+// Failures caused by the client are only logged at debug level.
+func callFailed(ctx context.Context, method string, err error) error {
+	st := status.Convert(toStatusError(err))
+
+	event := zerolog.Ctx(ctx).Debug()
+	if st.Code() == codes.Unknown || st.Code() == codes.Internal || st.Code() == codes.Unavailable {
+		event = zerolog.Ctx(ctx).Error()
+	}
+
+	event.Err(err).Str("method", method).Stringer("code", st.Code()).Msg("KV call failed.")
+
+	return st.Err()
+}
+
+// toStatusError maps host errors to gRPC status codes.
+func toStatusError(err error) error {
+	if errors.Is(err, ErrSupervisorStopped) || errors.Is(err, ErrSupervisorFailed) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return shared.ToStatusError(err)
+}
+
+// serveTLSConfig returns the TLS config of the serve listeners from the
+// KV_SERVE_TLS_* settings, nil if they are not set.
+func serveTLSConfig(get func(string) string) (*tls.Config, error) {
+	certFile, keyFile, caFile := get(EnvServeTLSCert), get(EnvServeTLSKey), get(EnvServeTLSCA)
+
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("%s and %s must be set together", EnvServeTLSCert, EnvServeTLSKey)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load serve TLS certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load serve TLS CA: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in serve TLS CA file %s", caFile)
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = pool
+	}
+
+	return config, nil
+}
+
+// runServe serves the KV store on every listen address until SIGINT or
+// SIGTERM, then stops gracefully. http:// addresses serve the REST gateway,
+// all others serve gRPC. Every listener uses tlsConfig unless it is nil.
+func runServe(kv *Supervisor, health *HealthMonitor, addresses []string, tlsConfig *tls.Config) error {
+	if len(addresses) == 0 {
+		addresses = []string{DefaultListen}
+	}
+
+	serverOptions := append(shared.MetricsServerOptions(), shared.TracingServerOptions()...)
+	if tlsConfig != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	grpcServer := grpc.NewServer(serverOptions...)
+	proto.RegisterKVServer(grpcServer, NewKVService(kv, health))
+
+	mux := http.NewServeMux()
+	mux.Handle("/", NewRESTGateway(kv).Handler())
+	mux.Handle(metricsPath, promhttp.HandlerFor(shared.MetricsRegistry, promhttp.HandlerOpts{
+		ErrorLog:      metricsErrorLog{},
+		ErrorHandling: promhttp.ContinueOnError,
+	}))
+
+	httpServer := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         tlsConfig,
+	}
+
+	listeners := []net.Listener{}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	errCh := make(chan error, len(addresses))
+
+	for _, address := range addresses {
+		l, err := listen(address, tlsConfig != nil)
+		if err != nil {
+			return err
+		}
+
+		listeners = append(listeners, l)
+
+		if strings.HasPrefix(address, "http://") {
+			zlog.Info().Str("address", l.Addr().String()).Bool("tls", tlsConfig != nil).Msg("Serving KV over HTTP.")
+
+			go func(l net.Listener) {
+				if tlsConfig != nil {
+					errCh <- httpServer.ServeTLS(l, "", "")
+				} else {
+					errCh <- httpServer.Serve(l)
+				}
+			}(l)
+
+			continue
+		}
+
+		zlog.Info().Str("address", l.Addr().String()).Str("network", l.Addr().Network()).Bool("tls", tlsConfig != nil).Msg("Serving KV over gRPC.")
+
+		go func(l net.Listener) {
+			errCh <- grpcServer.Serve(l)
+		}(l)
+	}
+
+	select {
+	case sig := <-shutdownSignals():
+		zlog.Info().Stringer("signal", sig).Msg("Stopping servers.")
+	case err := <-errCh:
+		grpcServer.Stop()
+		httpServer.Close()
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracefulStopTimeout)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	err := httpServer.Shutdown(ctx)
+	if err != nil {
+		zlog.Warn().Err(err).Msg("HTTP server graceful shutdown failed.")
+		httpServer.Close()
+	}
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		zlog.Warn().Dur("timeout", gracefulStopTimeout).Msg("Graceful stop timed out, closing connections.")
+		grpcServer.Stop()
+	}
+
+	return nil
+}
+
+// listen opens a listener for "tcp://host:port", "http://host:port" or
+// "unix:///path" addresses. TCP addresses must be loopback ones unless the
+// listener is secure, the KV service has no other authentication.
+func listen(address string, secure bool) (net.Listener, error) {
+	network, addr, found := strings.Cut(address, "://")
+	if !found {
+		return nil, fmt.Errorf("invalid listen address %q, expected tcp://host:port, http://host:port or unix:///path", address)
+	}
+
+	switch network {
+	case "tcp", "http":
+		network = "tcp"
+
+		if !secure && !isLoopback(addr) {
+			return nil, fmt.Errorf("refusing to listen on non-loopback address %q without TLS, set %s and %s", address, EnvServeTLSCert, EnvServeTLSKey)
+		}
+	case "unix":
+		err := removeSocket(addr)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported network %q in listen address %q", network, address)
+	}
+
+	return net.Listen(network, addr)
+}
+
+// isLoopback reports whether the host of a host:port address is localhost
+// or a loopback IP.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// removeSocket removes a socket left at path by a previous run, it refuses
+// to remove any other kind of file.
+func removeSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("refusing to remove %s to listen on it, it is not a socket", path)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/tinybit/go-plugin-log-example/shared"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+		wantHTTP int
+		wantErr  error // typed error remote clients get back, if any
+	}{
+		{
+			name:     "not found",
+			err:      fmt.Errorf("get hello: %w", shared.ErrNotFound),
+			wantCode: codes.NotFound,
+			wantHTTP: http.StatusNotFound,
+			wantErr:  shared.ErrNotFound,
+		},
+		{
+			name:     "invalid key",
+			err:      shared.ValidateKey("../hello"),
+			wantCode: codes.InvalidArgument,
+			wantHTTP: http.StatusBadRequest,
+			wantErr:  shared.ErrInvalidArgument,
+		},
+		{
+			name:     "invalid config",
+			err:      fmt.Errorf("%w: bad data dir", shared.ErrInvalidConfig),
+			wantCode: codes.InvalidArgument,
+			wantHTTP: http.StatusInternalServerError,
+			wantErr:  shared.ErrInvalidArgument,
+		},
+		{
+			name:     "unsupported",
+			err:      fmt.Errorf("list: %w", shared.ErrUnsupported),
+			wantCode: codes.Unimplemented,
+			wantHTTP: http.StatusNotImplemented,
+			wantErr:  shared.ErrUnsupported,
+		},
+		{
+			name:     "value too large",
+			err:      fmt.Errorf("put hello: %w", shared.ErrValueTooLarge),
+			wantCode: codes.ResourceExhausted,
+			wantHTTP: http.StatusRequestEntityTooLarge,
+			wantErr:  shared.ErrValueTooLarge,
+		},
+		{
+			name:     "supervisor stopped",
+			err:      ErrSupervisorStopped,
+			wantCode: codes.Unavailable,
+			wantHTTP: http.StatusServiceUnavailable,
+		},
+		{
+			name:     "supervisor failed",
+			err:      fmt.Errorf("%w: gave up after 3 failed restarts", ErrSupervisorFailed),
+			wantCode: codes.Unavailable,
+			wantHTTP: http.StatusServiceUnavailable,
+		},
+		{
+			name:     "plugin unavailable",
+			err:      status.Error(codes.Unavailable, "connection refused"),
+			wantCode: codes.Unavailable,
+			wantHTTP: http.StatusServiceUnavailable,
+		},
+		{
+			name:     "unknown",
+			err:      errors.New("disk on fire"),
+			wantCode: codes.Unknown,
+			wantHTTP: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := toStatusError(tc.err)
+
+			if code := status.Code(err); code != tc.wantCode {
+				t.Errorf("got gRPC code %v, want %v", code, tc.wantCode)
+			}
+
+			if code := restStatusCode(tc.err); code != tc.wantHTTP {
+				t.Errorf("got HTTP status %d, want %d", code, tc.wantHTTP)
+			}
+
+			if tc.wantErr != nil && !errors.Is(shared.FromStatusError(err), tc.wantErr) {
+				t.Errorf("got error %v back, want %v", shared.FromStatusError(err), tc.wantErr)
+			}
+		})
+	}
+}
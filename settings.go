@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	EnvLogLevel        = "KV_LOG_LEVEL"
+	EnvLogFormat       = "KV_LOG_FORMAT"
+	EnvLogOutput       = "KV_LOG_OUTPUT"
+	EnvShutdownTimeout = "KV_SHUTDOWN_TIMEOUT"
+	EnvHealthInterval  = "KV_HEALTH_INTERVAL"
+	EnvHealthTimeout   = "KV_HEALTH_TIMEOUT"
+)
+
+// Settings resolves host settings from command line flags, environment
+// variables and the config file, in that order of precedence. Settings are
+// named after their environment variables.
+type Settings struct {
+	configPath string
+	flags      map[string]string
+
+	mutex  sync.Mutex
+	config *HostConfig
+	file   map[string]string
+}
+
+// LoadSettings loads the config file at configPath (see LoadHostConfigFile)
+// and layers flags, keyed by environment variable name, on top of it.
+func LoadSettings(configPath string, flags map[string]string) (*Settings, error) {
+	config, err := LoadHostConfigFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &Settings{
+		configPath: configPath,
+		flags:      flags,
+		config:     config,
+		file:       config.Env(),
+	}
+
+	return settings, nil
+}
+
+// Get returns the value of a setting, empty if it is not set anywhere.
+func (s *Settings) Get(name string) string {
+	if value := s.flags[name]; value != "" {
+		return value
+	}
+
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file[name]
+}
+
+// Duration returns a duration setting, or def if it is not set.
+func (s *Settings) Duration(name string, def time.Duration) (time.Duration, error) {
+	str := s.Get(name)
+	if str == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s value %q, expected a positive duration like 5s", name, str)
+	}
+
+	return d, nil
+}
+
+// Config returns the loaded config file.
+func (s *Settings) Config() *HostConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.config
+}
+
+// ReloadConfig loads the config file again, flags and environment variables
+// keep taking precedence.
+func (s *Settings) ReloadConfig() error {
+	config, err := LoadHostConfigFile(s.configPath)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.config = config
+	s.file = config.Env()
+
+	return nil
+}
+
+// SupervisorOptions returns the default supervisor options with configured
+// timeouts applied.
+func (s *Settings) SupervisorOptions() (SupervisorOptions, error) {
+	opts := DefaultSupervisorOptions()
+
+	var err error
+	opts.ShutdownTimeout, err = s.Duration(EnvShutdownTimeout, opts.ShutdownTimeout)
+
+	return opts, err
+}
+
+// HealthMonitorOptions returns the default health monitor options with
+// configured timeouts applied.
+func (s *Settings) HealthMonitorOptions() (HealthMonitorOptions, error) {
+	opts := DefaultHealthMonitorOptions()
+
+	var err error
+	opts.Interval, err = s.Duration(EnvHealthInterval, opts.Interval)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Timeout, err = s.Duration(EnvHealthTimeout, opts.Timeout)
+
+	return opts, err
+}
@@ -0,0 +1,141 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tinybit/go-plugin-log-example/proto"
+)
+
+// Durability describes when a written value survives a crash.
+type Durability int
+
+const (
+	DurabilityUnspecified Durability = iota
+	DurabilityMemory                 // lost when the plugin exits
+	DurabilityOS                     // written to the OS, not fsynced
+	DurabilityFsync                  // fsynced before the call returns
+)
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityMemory:
+		return "memory"
+	case DurabilityOS:
+		return "os"
+	case DurabilityFsync:
+		return "fsync"
+	default:
+		return "unspecified"
+	}
+}
+
+// Capabilities lists the optional features supported by a plugin.
+type Capabilities struct {
+	Delete       bool
+	List         bool
+	TTL          bool
+	CAS          bool
+	Transactions bool
+	Streaming    bool
+	Watch        bool
+	ContentType  bool
+	Snapshot     bool
+	MaxValueSize uint64 // 0 means unlimited
+	Durability   Durability
+}
+
+// CapabilitiesProvider is optionally implemented by plugins to report their
+// capabilities. Plugins that don't implement it get capabilities derived
+// from the interfaces they implement.
+type CapabilitiesProvider interface {
+	Capabilities() (Capabilities, error)
+}
+
+func (c Capabilities) String() string {
+	return fmt.Sprintf("features=[%s] max_value_size=%d durability=%s",
+		strings.Join(c.Features(), ","), c.MaxValueSize, c.Durability)
+}
+
+// Features returns the names of the supported optional features.
+func (c Capabilities) Features() []string {
+	features := []string{}
+
+	for _, f := range []struct {
+		name      string
+		supported bool
+	}{
+		{"delete", c.Delete},
+		{"list", c.List},
+		{"ttl", c.TTL},
+		{"cas", c.CAS},
+		{"transactions", c.Transactions},
+		{"streaming", c.Streaming},
+		{"watch", c.Watch},
+		{"content_type", c.ContentType},
+		{"snapshot", c.Snapshot},
+	} {
+		if f.supported {
+			features = append(features, f.name)
+		}
+	}
+
+	return features
+}
+
+// capabilitiesOf derives capabilities of a plugin implementation.
+func capabilitiesOf(impl KV) (Capabilities, error) {
+	if provider, ok := impl.(CapabilitiesProvider); ok {
+		return provider.Capabilities()
+	}
+
+	_, isV2 := impl.(KVv2)
+	_, isLister := impl.(Lister)
+	_, isContentTypeStore := impl.(ContentTypeStore)
+	_, isSnapshotter := impl.(Snapshotter)
+
+	caps := Capabilities{
+		Delete:      isV2,
+		List:        isLister,
+		ContentType: isContentTypeStore,
+		Snapshot:    isSnapshotter,
+	}
+
+	return caps, nil
+}
+
+func capabilitiesFromProto(resp *proto.CapabilitiesResponse) Capabilities {
+	return Capabilities{
+		Delete:       resp.GetDelete(),
+		List:         resp.GetList(),
+		TTL:          resp.GetTtl(),
+		CAS:          resp.GetCas(),
+		Transactions: resp.GetTransactions(),
+		Streaming:    resp.GetStreaming(),
+		Watch:        resp.GetWatch(),
+		ContentType:  resp.GetContentType(),
+		Snapshot:     resp.GetSnapshot(),
+		MaxValueSize: resp.GetMaxValueSize(),
+		Durability:   Durability(resp.GetDurability()),
+	}
+}
+
+// CapabilitiesToProto converts capabilities to their wire representation.
+func CapabilitiesToProto(caps Capabilities) *proto.CapabilitiesResponse {
+	return &proto.CapabilitiesResponse{
+		Delete:       caps.Delete,
+		List:         caps.List,
+		Ttl:          caps.TTL,
+		Cas:          caps.CAS,
+		Transactions: caps.Transactions,
+		Streaming:    caps.Streaming,
+		Watch:        caps.Watch,
+		ContentType:  caps.ContentType,
+		Snapshot:     caps.Snapshot,
+		MaxValueSize: caps.MaxValueSize,
+		Durability:   proto.Durability(caps.Durability),
+	}
+}
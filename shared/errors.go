@@ -0,0 +1,108 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrUnsupported is returned by the host when the plugin does not
+	// support the requested operation.
+	ErrUnsupported = errors.New("operation is not supported by plugin")
+
+	// ErrValueTooLarge is returned by the host when a value exceeds the
+	// maximum value size reported by the plugin.
+	ErrValueTooLarge = errors.New("value exceeds maximum size supported by plugin")
+
+	// ErrNotFound is returned when a key does not exist. Plugins may return
+	// it or fs.ErrNotExist.
+	ErrNotFound = errors.New("key not found")
+
+	// ErrInvalidConfig is returned by plugins rejecting the config passed
+	// to Init.
+	ErrInvalidConfig = errors.New("invalid plugin config")
+
+	// ErrInvalidArgument is returned when the arguments of a call, such as
+	// its key, are rejected.
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// ToStatusError converts typed errors to gRPC status errors so that their
+// type survives the plugin boundary.
+func ToStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrUnsupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, ErrValueTooLarge):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrInvalidConfig), errors.Is(err, ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
+
+// FromStatusError converts gRPC status errors back to typed errors. Init
+// errors are converted by fromInitStatusError instead.
+func FromStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	switch st.Code() {
+	case codes.NotFound:
+		return wrapStatusMessage(ErrNotFound, st.Message())
+	case codes.Unimplemented:
+		return wrapStatusMessage(ErrUnsupported, st.Message())
+	case codes.ResourceExhausted:
+		return wrapStatusMessage(ErrValueTooLarge, st.Message())
+	case codes.InvalidArgument:
+		return wrapStatusMessage(ErrInvalidArgument, st.Message())
+	default:
+		return err
+	}
+}
+
+// fromInitStatusError converts gRPC status errors of Init calls, where an
+// invalid argument is the plugin config.
+func fromInitStatusError(err error) error {
+	if status.Code(err) == codes.InvalidArgument {
+		return wrapStatusMessage(ErrInvalidConfig, status.Convert(err).Message())
+	}
+
+	return FromStatusError(err)
+}
+
+// wrapStatusMessage wraps target with a status message, unless the message
+// already starts with the target text because the plugin wrapped it too.
+func wrapStatusMessage(target error, msg string) error {
+	rest, found := strings.CutPrefix(msg, target.Error())
+	if found {
+		return fmt.Errorf("%w%s", target, rest)
+	}
+
+	return fmt.Errorf("%w: %s", target, msg)
+}
@@ -5,12 +5,19 @@ package shared
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-plugin"
+	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
 	"github.com/tinybit/go-plugin-log-example/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -22,30 +29,52 @@ type GRPCClient struct {
 	ctx           context.Context
 	broker        *plugin.GRPCBroker
 	client        proto.KVClient
+	logHelper     LogHelper
+	hostConfig    HostConfig
+	metrics       MetricsReceiver
+	config        map[string]string
+	version       int
 	isInitialized bool
 	mutex         sync.Mutex
+	capabilities  *Capabilities
+	capsMutex     sync.Mutex
+
+	logServer      *grpc.Server
+	logServerMutex sync.Mutex
 }
 
-func NewGRPCClient(ctx context.Context, broker *plugin.GRPCBroker, conn *grpc.ClientConn) *GRPCClient {
+func NewGRPCClient(ctx context.Context, broker *plugin.GRPCBroker, conn *grpc.ClientConn, version int) *GRPCClient {
 	gClient := &GRPCClient{
-		ctx:    ctx,
-		broker: broker,
-		client: proto.NewKVClient(conn),
+		ctx:     ctx,
+		broker:  broker,
+		client:  proto.NewKVClient(conn),
+		version: version,
 	}
 
 	return gClient
 }
 
+// Version returns the negotiated plugin protocol version.
+func (m *GRPCClient) Version() int {
+	return m.version
+}
+
 func (m *GRPCClient) Ping() error {
 	_, err := m.client.Ping(m.ctx, &proto.Empty{})
 	return err
 }
 
 func (m *GRPCClient) Initialize() error {
-	return m.Init(0)
+	m.mutex.Lock()
+	config := m.config
+	m.mutex.Unlock()
+
+	return m.Init(0, config)
 }
 
-func (m *GRPCClient) Init(uint32) error {
+// Init starts the log broker server and passes config to the plugin. The
+// broker ID argument is ignored, the client allocates its own.
+func (m *GRPCClient) Init(_ uint32, config map[string]string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
@@ -55,14 +84,20 @@ func (m *GRPCClient) Init(uint32) error {
 	}
 
 	zlog.Info().Msg("Starting logger server...")
-	brokerID := m.startLogServer(MainLogHelper)
+	logHelper := m.logHelper
+	if logHelper == nil {
+		logHelper = MainLogHelper
+	}
+
+	brokerID := m.startLogServer(logHelper, m.hostConfig, m.metrics)
 
 	_, err := m.client.Init(m.ctx, &proto.InitRequest{
 		BrokerId: brokerID,
+		Config:   config,
 	})
 
 	if err != nil {
-		return err
+		return fromInitStatusError(err)
 	}
 
 	m.isInitialized = true
@@ -70,38 +105,366 @@ func (m *GRPCClient) Init(uint32) error {
 	return nil
 }
 
-func (m *GRPCClient) SetLogger(LogHelper) error {
+// SetLogger sets the helper serving plugin log calls, it must be called
+// before Init. MainLogHelper is used by default.
+func (m *GRPCClient) SetLogger(log LogHelper) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.logHelper = log
+
 	return nil
 }
 
+// SetConfig sets the plugin config passed by Initialize, it must be called
+// before Initialize.
+func (m *GRPCClient) SetConfig(config map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.config = config
+}
+
+// SetHostConfig sets the HostConfig served to the plugin next to its log
+// helper, it must be called before Initialize.
+func (m *GRPCClient) SetHostConfig(config HostConfig) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.hostConfig = config
+}
+
+// SetMetrics sets the receiver of metrics reported by the plugin, served
+// next to its log helper. It must be called before Initialize.
+func (m *GRPCClient) SetMetrics(metrics MetricsReceiver) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.metrics = metrics
+}
+
+// Capabilities returns the features supported by the plugin. The result is
+// fetched once and cached for the lifetime of the client.
+func (m *GRPCClient) Capabilities() (Capabilities, error) {
+	m.capsMutex.Lock()
+	defer m.capsMutex.Unlock()
+
+	if m.capabilities != nil {
+		return *m.capabilities, nil
+	}
+
+	caps, err := m.fetchCapabilities()
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	m.capabilities = &caps
+
+	return caps, nil
+}
+
+func (m *GRPCClient) fetchCapabilities() (Capabilities, error) {
+	if m.version < PluginProtocolVersionV2 {
+		return Capabilities{}, nil
+	}
+
+	resp, err := m.client.Capabilities(m.ctx, &proto.Empty{})
+	if status.Code(err) == codes.Unimplemented {
+		// early v2 plugins without the Capabilities RPC
+		return Capabilities{Delete: true}, nil
+	}
+
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	return capabilitiesFromProto(resp), nil
+}
+
 func (m *GRPCClient) Put(key string, value []byte) error {
-	_, err := m.client.Put(m.ctx, &proto.PutRequest{
-		Key:   key,
-		Value: value,
+	return m.PutWithContentType(key, value, "")
+}
+
+// PutWithContentType stores a value along with its content type. Plugins
+// that don't keep content types store only the value.
+func (m *GRPCClient) PutWithContentType(key string, value []byte, contentType string) error {
+	return m.PutContext(m.ctx, key, value, contentType)
+}
+
+// PutContext is PutWithContentType for a call traced by ctx.
+func (m *GRPCClient) PutContext(ctx context.Context, key string, value []byte, contentType string) error {
+	caps, err := m.Capabilities()
+	if err != nil {
+		return err
+	}
+
+	if caps.MaxValueSize > 0 && uint64(len(value)) > caps.MaxValueSize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrValueTooLarge, len(value), caps.MaxValueSize)
+	}
+
+	ctx, done := m.startCall(ctx, "Put")
+
+	_, err = m.client.Put(ctx, &proto.PutRequest{
+		Key:         key,
+		Value:       value,
+		ContentType: contentType,
 	})
-	return err
+	return done(FromStatusError(err))
 }
 
 func (m *GRPCClient) Get(key string) ([]byte, error) {
-	resp, err := m.client.Get(m.ctx, &proto.GetRequest{
+	value, _, err := m.GetWithContentType(key)
+	return value, err
+}
+
+// GetWithContentType returns a value and its content type, which is empty
+// if the plugin doesn't keep content types.
+func (m *GRPCClient) GetWithContentType(key string) ([]byte, string, error) {
+	return m.GetContext(m.ctx, key)
+}
+
+// GetContext is GetWithContentType for a call traced by ctx.
+func (m *GRPCClient) GetContext(ctx context.Context, key string) ([]byte, string, error) {
+	ctx, done := m.startCall(ctx, "Get")
+
+	resp, err := m.client.Get(ctx, &proto.GetRequest{
 		Key: key,
 	})
+	if err != nil {
+		return nil, "", done(FromStatusError(err))
+	}
+
+	done(nil)
+
+	return resp.Value, resp.ContentType, nil
+}
+
+func (m *GRPCClient) Delete(key string) error {
+	return m.DeleteContext(m.ctx, key)
+}
+
+// DeleteContext is Delete for a call traced by ctx.
+func (m *GRPCClient) DeleteContext(ctx context.Context, key string) error {
+	caps, err := m.Capabilities()
+	if err != nil {
+		return err
+	}
+
+	if !caps.Delete {
+		return ErrUnsupported
+	}
+
+	ctx, done := m.startCall(ctx, "Delete")
+
+	_, err = m.client.Delete(ctx, &proto.DeleteRequest{
+		Key: key,
+	})
+	return done(FromStatusError(err))
+}
+
+// List returns all keys starting with prefix.
+func (m *GRPCClient) List(prefix string) ([]string, error) {
+	return m.ListContext(m.ctx, prefix)
+}
+
+// ListContext is List for a call traced by ctx.
+func (m *GRPCClient) ListContext(ctx context.Context, prefix string) ([]string, error) {
+	caps, err := m.Capabilities()
 	if err != nil {
 		return nil, err
 	}
 
-	return resp.Value, nil
+	if !caps.List {
+		return nil, ErrUnsupported
+	}
+
+	ctx, done := m.startCall(ctx, "List")
+
+	resp, err := m.client.List(ctx, &proto.ListRequest{
+		Prefix: prefix,
+	})
+	if err != nil {
+		return nil, done(FromStatusError(err))
+	}
+
+	done(nil)
+
+	return resp.Keys, nil
+}
+
+// Health checks the plugin health. Plugins without the Health RPC are
+// considered healthy if they answer Ping.
+func (m *GRPCClient) Health(timeout time.Duration) (Health, error) {
+	ctx, cancel := context.WithTimeout(m.ctx, timeout)
+	defer cancel()
+
+	if m.version >= PluginProtocolVersionV2 {
+		resp, err := m.client.Health(ctx, &proto.Empty{})
+		if status.Code(err) != codes.Unimplemented {
+			if err != nil {
+				return Health{}, err
+			}
+
+			return healthFromProto(resp), nil
+		}
+	}
+
+	_, err := m.client.Ping(ctx, &proto.Empty{})
+	if err != nil {
+		return Health{}, err
+	}
+
+	return Health{Status: HealthOK}, nil
 }
 
-func (m *GRPCClient) startLogServer(log LogHelper) (brokerID uint32) {
+// SetLogLevel changes the minimum level of plugin log messages. Plugins
+// speaking protocol version 1 don't support it.
+func (m *GRPCClient) SetLogLevel(level LogLevel) error {
+	if m.version < PluginProtocolVersionV2 {
+		return ErrUnsupported
+	}
+
+	_, err := m.client.SetLogLevel(m.ctx, &proto.SetLogLevelRequest{
+		Level: int32(level),
+	})
+	if status.Code(err) == codes.Unimplemented {
+		return ErrUnsupported
+	}
+
+	return FromStatusError(err)
+}
+
+// Snapshot writes a consistent snapshot of the plugin state to w.
+func (m *GRPCClient) Snapshot(w io.Writer) error {
+	caps, err := m.Capabilities()
+	if err != nil {
+		return err
+	}
+
+	if !caps.Snapshot {
+		return ErrUnsupported
+	}
+
+	stream, err := m.client.Snapshot(m.ctx, &proto.Empty{})
+	if err != nil {
+		return FromStatusError(err)
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return FromStatusError(err)
+		}
+
+		_, err = w.Write(chunk.Data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Restore replaces the plugin state with a snapshot read from r, the plugin
+// applies it only once it was received completely.
+func (m *GRPCClient) Restore(r io.Reader) error {
+	caps, err := m.Capabilities()
+	if err != nil {
+		return err
+	}
+
+	if !caps.Snapshot {
+		return ErrUnsupported
+	}
+
+	// cancelling the stream when reading r fails makes the plugin see an
+	// error instead of the end of the snapshot, so it doesn't apply it
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+
+	stream, err := m.client.Restore(ctx)
+	if err != nil {
+		return FromStatusError(err)
+	}
+
+	// Send fails with io.EOF when the plugin ended the call, its error is
+	// returned by CloseAndRecv
+	_, err = io.Copy(&snapshotChunkWriter{send: stream.Send}, r)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	_, err = stream.CloseAndRecv()
+
+	return FromStatusError(err)
+}
+
+// Shutdown asks the plugin to flush its state and close the log broker
+// connection, then stops the host side log server. Plugins speaking
+// protocol version 1 don't support it and are left to be killed.
+func (m *GRPCClient) Shutdown(timeout time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.version < PluginProtocolVersionV2 {
+		return ErrUnsupported
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, timeout)
+	defer cancel()
+
+	_, err := m.client.Shutdown(ctx, &proto.Empty{})
+	if status.Code(err) == codes.Unimplemented {
+		err = ErrUnsupported
+	}
+
+	m.logServerMutex.Lock()
+	defer m.logServerMutex.Unlock()
+
+	if m.logServer != nil {
+		m.logServer.Stop()
+		m.logServer = nil
+	}
+
+	return err
+}
+
+// startCall tags a KV call with the request ID of ctx, or a new one, and
+// sends it to the plugin in metadata. The returned function logs the end of
+// the call with the request ID, so that main and plugin log lines of the
+// call can be correlated, and returns err.
+func (m *GRPCClient) startCall(ctx context.Context, method string) (context.Context, func(err error) error) {
+	ctx = outgoingRequestContext(ctx)
+	start := time.Now()
+
+	return ctx, func(err error) error {
+		zerolog.Ctx(ctx).Debug().Err(err).Str("method", method).Dur("duration", time.Since(start)).Msg("Plugin call completed.")
+		return err
+	}
+}
+
+func (m *GRPCClient) startLogServer(log LogHelper, hostConfig HostConfig, metrics MetricsReceiver) (brokerID uint32) {
 	// start logger server and remember brokerID
 	addHelperServer := &GRPCLogHelperServer{Impl: log}
 
-	var s *grpc.Server
 	serverFunc := func(opts []grpc.ServerOption) *grpc.Server {
-		s = grpc.NewServer(opts...)
+		s := grpc.NewServer(opts...)
 		proto.RegisterLogHelperServer(s, addHelperServer)
 
+		if hostConfig != nil {
+			proto.RegisterHostConfigServer(s, &GRPCHostConfigServer{Impl: hostConfig})
+		}
+
+		if metrics != nil {
+			proto.RegisterMetricsServer(s, &GRPCMetricsServer{Impl: metrics})
+		}
+
+		m.logServerMutex.Lock()
+		m.logServer = s
+		m.logServerMutex.Unlock()
+
 		return s
 	}
 
@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"context"
+
+	"github.com/tinybit/go-plugin-log-example/proto"
+)
+
+// ConfigValue is the value of a host config key, Found is false when the
+// key is not set.
+type ConfigValue struct {
+	Key   string
+	Value string
+	Found bool
+}
+
+// HostConfig is served by the host to its plugins over the broker, it gives
+// access to the plugin section of the host config file.
+type HostConfig interface {
+	Get(key string) (ConfigValue, error)
+
+	// Watch calls fn with the current value of keys, then again every time
+	// one of them changes, until ctx is done or fn returns an error. No keys
+	// means all keys.
+	Watch(ctx context.Context, keys []string, fn func(ConfigValue) error) error
+}
+
+// HostConfigConsumer is optionally implemented by plugins that query host
+// config at runtime. SetHostConfig is called after Init.
+type HostConfigConsumer interface {
+	SetHostConfig(config HostConfig) error
+}
+
+// GRPCHostConfigClient is the plugin side implementation of HostConfig.
+type GRPCHostConfigClient struct{ client proto.HostConfigClient }
+
+func (m *GRPCHostConfigClient) Get(key string) (ConfigValue, error) {
+	resp, err := m.client.Get(context.Background(), &proto.HostConfigGetRequest{Key: key})
+	if err != nil {
+		return ConfigValue{}, err
+	}
+
+	return configValueFromProto(resp), nil
+}
+
+func (m *GRPCHostConfigClient) Watch(ctx context.Context, keys []string, fn func(ConfigValue) error) error {
+	stream, err := m.client.Watch(ctx, &proto.HostConfigWatchRequest{Keys: keys})
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		err = fn(configValueFromProto(resp))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// GRPCHostConfigServer is the gRPC server that GRPCHostConfigClient talks to.
+type GRPCHostConfigServer struct {
+	proto.UnimplementedHostConfigServer
+	Impl HostConfig
+}
+
+func (m *GRPCHostConfigServer) Get(ctx context.Context, req *proto.HostConfigGetRequest) (*proto.HostConfigValue, error) {
+	value, err := m.Impl.Get(req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return configValueToProto(value), nil
+}
+
+func (m *GRPCHostConfigServer) Watch(req *proto.HostConfigWatchRequest, stream proto.HostConfig_WatchServer) error {
+	return m.Impl.Watch(stream.Context(), req.Keys, func(value ConfigValue) error {
+		return stream.Send(configValueToProto(value))
+	})
+}
+
+func configValueFromProto(value *proto.HostConfigValue) ConfigValue {
+	return ConfigValue{Key: value.Key, Value: value.Value, Found: value.Found}
+}
+
+func configValueToProto(value ConfigValue) *proto.HostConfigValue {
+	return &proto.HostConfigValue{Key: value.Key, Value: value.Value, Found: value.Found}
+}
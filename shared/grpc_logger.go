@@ -5,21 +5,59 @@ package shared
 
 import (
 	"context"
+	"sync/atomic"
 
 	zlog "github.com/rs/zerolog/log"
 	"github.com/tinybit/go-plugin-log-example/proto"
 )
 
-// GRPCClient is an implementation of KV that talks over RPC.
-type GRPCLogHelperClient struct{ client proto.LogHelperClient }
+// GRPCLogHelperClient is an implementation of LogHelper that talks over RPC.
+// Messages below its level are dropped before crossing the wire.
+type GRPCLogHelperClient struct {
+	client proto.LogHelperClient
+	level  atomic.Int32
+}
+
+func NewGRPCLogHelperClient(client proto.LogHelperClient) *GRPCLogHelperClient {
+	return &GRPCLogHelperClient{client: client}
+}
+
+// SetLevel sets the minimum level of sent messages, unspecified levels
+// count as info.
+func (m *GRPCLogHelperClient) SetLevel(level LogLevel) {
+	m.level.Store(int32(level))
+}
 
 func (m *GRPCLogHelperClient) Log(level int, msg string) error {
+	return m.LogContext(context.Background(), level, msg)
+}
+
+// LogContext sends a message along with the trace context and request ID
+// of ctx, so that the host can tie it to the call being handled.
+func (m *GRPCLogHelperClient) LogContext(ctx context.Context, level int, msg string) error {
+	if LogLevel(level) == LogLevelUnspecified {
+		level = int(LogLevelInfo)
+	}
+
+	if int32(level) < m.level.Load() {
+		logMessagesDropped.WithLabelValues(LogLevel(level).String(), "level").Inc()
+		return nil
+	}
+
+	traceID, spanID := spanIDs(ctx)
+
+	// the message is tied to the call by its IDs, it must not be canceled
+	// with it
 	_, err := m.client.Log(context.Background(), &proto.LogRequest{
-		Level:   int32(level),
-		Message: msg,
+		Level:     int32(level),
+		Message:   msg,
+		TraceId:   traceID,
+		SpanId:    spanID,
+		RequestId: RequestIDFromContext(ctx),
 	})
 
 	if err != nil {
+		logMessagesDropped.WithLabelValues(LogLevel(level).String(), "error").Inc()
 		zlog.Error().Msgf("Could not start log helper client: %v", err)
 		return err
 	}
@@ -34,7 +72,24 @@ type GRPCLogHelperServer struct {
 }
 
 func (m *GRPCLogHelperServer) Log(ctx context.Context, req *proto.LogRequest) (resp *proto.Empty, err error) {
-	err = m.Impl.Log(int(req.GetLevel()), req.GetMessage())
+	level := LogLevel(req.GetLevel())
+	if level == LogLevelUnspecified {
+		level = LogLevelInfo
+	}
+
+	logMessagesForwarded.WithLabelValues(level.String()).Inc()
+
+	if impl, ok := m.Impl.(ContextLogHelper); ok {
+		ctx = contextWithSpanIDs(ctx, req.GetTraceId(), req.GetSpanId())
+		if req.GetRequestId() != "" {
+			ctx = ContextWithRequestID(ctx, req.GetRequestId())
+		}
+
+		err = impl.LogContext(ctx, int(req.GetLevel()), req.GetMessage())
+	} else {
+		err = m.Impl.Log(int(req.GetLevel()), req.GetMessage())
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tinybit/go-plugin-log-example/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// metricsReportInterval is how often plugins report the metrics of their
+// MetricsRegistry to the host.
+const metricsReportInterval = 10 * time.Second
+
+// MetricsReceiver is served by the host to its plugins over the broker, it
+// receives the metrics gathered in the plugin process. Plugins register
+// their own counters, gauges and histograms in MetricsRegistry and
+// GRPCServer reports them, next to its call metrics.
+type MetricsReceiver interface {
+	Report(families []*dto.MetricFamily) error
+}
+
+// GRPCMetricsClient is the plugin side implementation of MetricsReceiver.
+type GRPCMetricsClient struct{ client proto.MetricsClient }
+
+func (m *GRPCMetricsClient) Report(families []*dto.MetricFamily) error {
+	req := &proto.MetricsReportRequest{}
+
+	for _, family := range families {
+		data, err := protobuf.Marshal(family)
+		if err != nil {
+			return err
+		}
+
+		req.Families = append(req.Families, data)
+	}
+
+	_, err := m.client.Report(context.Background(), req)
+
+	return err
+}
+
+// GRPCMetricsServer is the host side of the Metrics service.
+type GRPCMetricsServer struct {
+	proto.UnimplementedMetricsServer
+	Impl MetricsReceiver
+}
+
+func (m *GRPCMetricsServer) Report(ctx context.Context, req *proto.MetricsReportRequest) (*proto.Empty, error) {
+	families := make([]*dto.MetricFamily, 0, len(req.Families))
+
+	for i, data := range req.Families {
+		family := &dto.MetricFamily{}
+
+		err := protobuf.Unmarshal(data, family)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("metric family %d: %v", i, err))
+		}
+
+		families = append(families, family)
+	}
+
+	err := m.Impl.Report(families)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.Empty{}, nil
+}
+
+// reportMetrics reports MetricsRegistry to the host on every interval until
+// ctx is done, and a last time then. It gives up if the host doesn't serve
+// the Metrics service.
+func reportMetrics(ctx context.Context, receiver MetricsReceiver, interval time.Duration) {
+	report := func() error {
+		// report what could be gathered even if some collectors failed
+		families, _ := MetricsRegistry.Gather()
+		return receiver.Report(families)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := report()
+		if status.Code(err) == codes.Unimplemented {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			report()
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -4,22 +4,40 @@
 package shared
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/hashicorp/go-plugin"
 	"github.com/tinybit/go-plugin-log-example/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Here is the gRPC server that GRPCClient talks to.
 type GRPCServer struct {
 	proto.UnimplementedKVServer
-	Impl          KV // This is the real implementation
-	broker        *plugin.GRPCBroker
+	Impl   KV // This is the real implementation
+	broker *plugin.GRPCBroker
+
+	// mutex guards the broker connection and its clients, replaced by
+	// every Init and closed by Shutdown
+	mutex         sync.Mutex
 	brokerID      uint32
 	logServerConn *grpc.ClientConn
 	logClient     *GRPCLogHelperClient
+	hostConfig    *GRPCHostConfigClient
+	metrics       *GRPCMetricsClient
+
+	stopMetrics    context.CancelFunc
+	metricsStopped chan struct{}
+
+	// logLevel is the level last set by the host, applied to the log clients
+	// of later Inits, LogLevelUnspecified if none was set
+	logLevel LogLevel
 }
 
 func (m *GRPCServer) Ping(ctx context.Context, req *proto.Empty) (*proto.Empty, error) {
@@ -27,16 +45,23 @@ func (m *GRPCServer) Ping(ctx context.Context, req *proto.Empty) (*proto.Empty,
 }
 
 func (m *GRPCServer) Init(ctx context.Context, req *proto.InitRequest) (*proto.Empty, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	m.brokerID = req.BrokerId
 
+	// the reporter of a previous Init sends through the connection replaced
+	// below
+	m.stopReportingMetrics()
+
 	err := m.connectToLoggerServer()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to logger server in stresshouse process: %v", err)
 	}
 
-	err = m.Impl.Init(m.brokerID)
+	err = m.Impl.Init(m.brokerID, req.Config)
 	if err != nil {
-		return nil, err
+		return nil, ToStatusError(err)
 	}
 
 	err = m.Impl.SetLogger(m.logClient)
@@ -44,16 +69,222 @@ func (m *GRPCServer) Init(ctx context.Context, req *proto.InitRequest) (*proto.E
 		return nil, err
 	}
 
+	if impl, ok := m.Impl.(HostConfigConsumer); ok {
+		err = impl.SetHostConfig(m.hostConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.startReportingMetrics()
+
 	return &proto.Empty{}, nil
 }
 
 func (m *GRPCServer) Put(ctx context.Context, req *proto.PutRequest) (*proto.Empty, error) {
-	return &proto.Empty{}, m.Impl.Put(req.Key, req.Value)
+	ctx = IncomingRequestContext(ctx)
+
+	var err error
+
+	if impl, ok := m.Impl.(ContextKV); ok {
+		err = impl.PutContext(ctx, req.Key, req.Value, req.ContentType)
+	} else if impl, ok := m.Impl.(ContentTypeStore); ok && req.ContentType != "" {
+		err = impl.PutWithContentType(req.Key, req.Value, req.ContentType)
+	} else {
+		err = m.Impl.Put(req.Key, req.Value)
+	}
+
+	if err != nil {
+		return nil, ToStatusError(err)
+	}
+
+	return &proto.Empty{}, nil
 }
 
 func (m *GRPCServer) Get(ctx context.Context, req *proto.GetRequest) (*proto.GetResponse, error) {
-	v, err := m.Impl.Get(req.Key)
-	return &proto.GetResponse{Value: v}, err
+	ctx = IncomingRequestContext(ctx)
+
+	resp := &proto.GetResponse{}
+	var err error
+
+	if impl, ok := m.Impl.(ContextKV); ok {
+		resp.Value, resp.ContentType, err = impl.GetContext(ctx, req.Key)
+	} else if impl, ok := m.Impl.(ContentTypeStore); ok {
+		resp.Value, resp.ContentType, err = impl.GetWithContentType(req.Key)
+	} else {
+		resp.Value, err = m.Impl.Get(req.Key)
+	}
+
+	if err != nil {
+		return nil, ToStatusError(err)
+	}
+
+	return resp, nil
+}
+
+func (m *GRPCServer) Delete(ctx context.Context, req *proto.DeleteRequest) (*proto.Empty, error) {
+	ctx = IncomingRequestContext(ctx)
+
+	impl, ok := m.Impl.(KVv2)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not implement Delete")
+	}
+
+	var err error
+
+	if ctxImpl, ok := m.Impl.(ContextKV); ok {
+		err = ctxImpl.DeleteContext(ctx, req.Key)
+	} else {
+		err = impl.Delete(req.Key)
+	}
+
+	if err != nil {
+		return nil, ToStatusError(err)
+	}
+
+	return &proto.Empty{}, nil
+}
+
+func (m *GRPCServer) List(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error) {
+	ctx = IncomingRequestContext(ctx)
+
+	impl, ok := m.Impl.(Lister)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not implement List")
+	}
+
+	var keys []string
+	var err error
+
+	if ctxImpl, ok := m.Impl.(ContextKV); ok {
+		keys, err = ctxImpl.ListContext(ctx, req.Prefix)
+	} else {
+		keys, err = impl.List(req.Prefix)
+	}
+
+	if err != nil {
+		return nil, ToStatusError(err)
+	}
+
+	return &proto.ListResponse{Keys: keys}, nil
+}
+
+func (m *GRPCServer) Capabilities(ctx context.Context, req *proto.Empty) (*proto.CapabilitiesResponse, error) {
+	caps, err := capabilitiesOf(m.Impl)
+	if err != nil {
+		return nil, err
+	}
+
+	return CapabilitiesToProto(caps), nil
+}
+
+func (m *GRPCServer) Health(ctx context.Context, req *proto.Empty) (*proto.HealthResponse, error) {
+	health, err := healthOf(m.Impl)
+	if err != nil {
+		return nil, err
+	}
+
+	return healthToProto(health), nil
+}
+
+// SetLogLevel changes the level of messages sent to the host, and of the
+// plugin's own logger if it implements LogLevelSetter.
+func (m *GRPCServer) SetLogLevel(ctx context.Context, req *proto.SetLogLevelRequest) (*proto.Empty, error) {
+	level := LogLevel(req.Level)
+
+	m.mutex.Lock()
+	m.logLevel = level
+	logClient := m.logClient
+	m.mutex.Unlock()
+
+	if logClient != nil {
+		logClient.SetLevel(level)
+	}
+
+	if setter, ok := m.Impl.(LogLevelSetter); ok {
+		err := setter.SetLogLevel(level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &proto.Empty{}, nil
+}
+
+// Snapshot streams a snapshot written by the plugin in chunks.
+func (m *GRPCServer) Snapshot(req *proto.Empty, stream proto.KV_SnapshotServer) error {
+	impl, ok := m.Impl.(Snapshotter)
+	if !ok {
+		return status.Error(codes.Unimplemented, "plugin does not implement Snapshot")
+	}
+
+	w := bufio.NewWriterSize(&snapshotChunkWriter{send: stream.Send}, snapshotChunkSize)
+
+	err := impl.Snapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+
+	return ToStatusError(err)
+}
+
+// Restore passes the received snapshot chunks to the plugin as one stream.
+func (m *GRPCServer) Restore(stream proto.KV_RestoreServer) error {
+	impl, ok := m.Impl.(Snapshotter)
+	if !ok {
+		return status.Error(codes.Unimplemented, "plugin does not implement Restore")
+	}
+
+	r := &snapshotChunkReader{recv: stream.Recv}
+
+	err := impl.Restore(r)
+	if err == nil {
+		err = r.readAll()
+	}
+
+	if err != nil {
+		return ToStatusError(err)
+	}
+
+	return stream.SendAndClose(&proto.Empty{})
+}
+
+// Shutdown lets the plugin flush its state and closes the log broker
+// connection. The host calls it right before killing the plugin process.
+func (m *GRPCServer) Shutdown(ctx context.Context, req *proto.Empty) (*proto.Empty, error) {
+	var closeErr error
+
+	// close the implementation first, it may still log through the host
+	if closer, ok := m.Impl.(Closer); ok {
+		closeErr = closer.Close()
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// send the last metrics before the connection goes away
+	m.stopReportingMetrics()
+
+	// export the spans of the process before it is killed
+	err := flushTracing(ctx)
+	if err != nil {
+		closeErr = errors.Join(closeErr, err)
+	}
+
+	if m.logServerConn != nil {
+		err := m.logServerConn.Close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+
+		m.logServerConn = nil
+	}
+
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	return &proto.Empty{}, nil
 }
 
 func (m *GRPCServer) connectToLoggerServer() error {
@@ -62,10 +293,45 @@ func (m *GRPCServer) connectToLoggerServer() error {
 		return err
 	}
 
+	// the connection of a previous Init is no longer served by the host
+	if m.logServerConn != nil {
+		m.logServerConn.Close()
+	}
+
 	m.logServerConn = conn
-	m.logClient = &GRPCLogHelperClient{proto.NewLogHelperClient(conn)}
+	m.logClient = NewGRPCLogHelperClient(proto.NewLogHelperClient(conn))
+	if m.logLevel != LogLevelUnspecified {
+		m.logClient.SetLevel(m.logLevel)
+	}
+	m.hostConfig = &GRPCHostConfigClient{proto.NewHostConfigClient(conn)}
+	m.metrics = &GRPCMetricsClient{proto.NewMetricsClient(conn)}
 
 	return nil
 }
 
-//defer conn.Close()
+// startReportingMetrics reports the metrics of the plugin process to the
+// host until stopReportingMetrics.
+func (m *GRPCServer) startReportingMetrics() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stopMetrics = cancel
+	stopped := make(chan struct{})
+	m.metricsStopped = stopped
+	metrics := m.metrics
+
+	go func() {
+		defer close(stopped)
+		reportMetrics(ctx, metrics, metricsReportInterval)
+	}()
+}
+
+// stopReportingMetrics sends the last metrics and stops the reporter, if it
+// is running.
+func (m *GRPCServer) stopReportingMetrics() {
+	if m.stopMetrics == nil {
+		return
+	}
+
+	m.stopMetrics()
+	<-m.metricsStopped
+	m.stopMetrics = nil
+}
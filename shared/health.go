@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"github.com/tinybit/go-plugin-log-example/proto"
+)
+
+// HealthStatus is the health of the plugin as reported by the plugin itself.
+type HealthStatus int
+
+const (
+	HealthUnspecified HealthStatus = iota
+	HealthOK
+	HealthDegraded
+	HealthUnhealthy
+)
+
+func (h HealthStatus) String() string {
+	switch h {
+	case HealthOK:
+		return "ok"
+	case HealthDegraded:
+		return "degraded"
+	case HealthUnhealthy:
+		return "unhealthy"
+	default:
+		return "unspecified"
+	}
+}
+
+// Health is the result of a plugin health check. Reasons explain a degraded
+// or unhealthy status, e.g. "disk full" or "read-only filesystem".
+type Health struct {
+	Status  HealthStatus
+	Reasons []string
+}
+
+// HealthReporter is optionally implemented by plugins to report their
+// health. Plugins that don't implement it are healthy as long as Ping works.
+type HealthReporter interface {
+	Health() (Health, error)
+}
+
+// healthOf checks the health of a plugin implementation.
+func healthOf(impl KV) (Health, error) {
+	if reporter, ok := impl.(HealthReporter); ok {
+		return reporter.Health()
+	}
+
+	err := impl.Ping()
+	if err != nil {
+		return Health{Status: HealthUnhealthy, Reasons: []string{err.Error()}}, nil
+	}
+
+	return Health{Status: HealthOK}, nil
+}
+
+func healthFromProto(resp *proto.HealthResponse) Health {
+	return Health{
+		Status:  HealthStatus(resp.GetStatus()),
+		Reasons: resp.GetReasons(),
+	}
+}
+
+func healthToProto(health Health) *proto.HealthResponse {
+	return &proto.HealthResponse{
+		Status:  proto.HealthStatus(health.Status),
+		Reasons: health.Reasons,
+	}
+}
@@ -6,6 +6,7 @@ package shared
 
 import (
 	"context"
+	"io"
 
 	"google.golang.org/grpc"
 
@@ -17,20 +18,71 @@ type LogHelper interface {
 	Log(level int, msg string) error
 }
 
+// ContextLogHelper is implemented by log helpers that can tie messages to
+// the call being handled, ctx carries its trace context.
+type ContextLogHelper interface {
+	LogContext(ctx context.Context, level int, msg string) error
+}
+
 // KV is the interface that we're exposing as a plugin.
 type KV interface {
 	Ping() error
-	Init(brokerID uint32) error
+	Init(brokerID uint32, config map[string]string) error
 	SetLogger(log LogHelper) error
 	Put(key string, value []byte) error
 	Get(key string) ([]byte, error)
 }
 
+// KVv2 is the interface of plugins speaking protocol version 2.
+type KVv2 interface {
+	KV
+	Delete(key string) error
+}
+
+// Lister is optionally implemented by plugins that can list keys.
+type Lister interface {
+	List(prefix string) ([]string, error)
+}
+
+// ContentTypeStore is optionally implemented by plugins that keep the
+// content type of stored values.
+type ContentTypeStore interface {
+	PutWithContentType(key string, value []byte, contentType string) error
+	GetWithContentType(key string) ([]byte, string, error)
+}
+
+// ContextKV is optionally implemented by plugins that want the context of
+// KV calls, which carries the trace context of the host call. Its methods
+// are called instead of the ones they mirror, an empty content type stores
+// only the value like Put. Capabilities are still derived from the other
+// interfaces.
+type ContextKV interface {
+	PutContext(ctx context.Context, key string, value []byte, contentType string) error
+	GetContext(ctx context.Context, key string) ([]byte, string, error)
+	DeleteContext(ctx context.Context, key string) error
+	ListContext(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Snapshotter is optionally implemented by plugins that can write a
+// consistent point-in-time snapshot of their state, in a format of their
+// choice, and atomically replace their state with one.
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+// Closer is optionally implemented by plugins that need to flush state
+// before the plugin process is killed.
+type Closer interface {
+	Close() error
+}
+
 // This is the implementation of plugin.GRPCPlugin so we can serve/consume this.
 type KVGRPCPlugin struct {
 	plugin.Plugin
 	Impl      KV
 	ClientPtr *GRPCClient
+	Version   int // protocol version this plugin set is registered for
 }
 
 func (p *KVGRPCPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
@@ -39,7 +91,7 @@ func (p *KVGRPCPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) err
 }
 
 func (p *KVGRPCPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
-	gClient := NewGRPCClient(ctx, broker, conn)
+	gClient := NewGRPCClient(ctx, broker, conn, p.Version)
 	p.ClientPtr = gClient
 
 	return gClient, nil
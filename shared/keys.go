@@ -0,0 +1,20 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateKey returns ErrInvalidArgument for keys that could name a file
+// outside of a store when used in a path: keys containing '/', '\', ".." or
+// NUL. Both the host and plugins check keys received from remote clients.
+func ValidateKey(key string) error {
+	if strings.ContainsAny(key, "/\\\x00") || strings.Contains(key, "..") {
+		return fmt.Errorf("%w: invalid key %q: keys may not contain '/', '\\', \"..\" or NUL", ErrInvalidArgument, key)
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{key: "hello", valid: true},
+		{key: "hello.world", valid: true},
+		{key: "a.b.c", valid: true},
+		{key: ".hidden", valid: true},
+		{key: "with space", valid: true},
+		{key: "ünïcödé", valid: true},
+		{key: "/", valid: false},
+		{key: "a/b", valid: false},
+		{key: "/etc/passwd", valid: false},
+		{key: `a\b`, valid: false},
+		{key: "..", valid: false},
+		{key: "a..b", valid: false},
+		{key: "../escape", valid: false},
+		{key: "a\x00b", valid: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.key, func(t *testing.T) {
+			err := ValidateKey(tc.key)
+
+			if tc.valid && err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			if !tc.valid && !errors.Is(err, ErrInvalidArgument) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidArgument)
+			}
+		})
+	}
+}
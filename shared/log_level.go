@@ -0,0 +1,53 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LogLevel is the level of plugin log messages, values match hclog levels.
+type LogLevel int
+
+const (
+	LogLevelUnspecified LogLevel = iota // treated as info
+	LogLevelTrace
+	LogLevelDebug
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelTrace:
+		return "trace"
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseLogLevel parses trace, debug, info, warn or error.
+func ParseLogLevel(str string) (LogLevel, error) {
+	for level := LogLevelTrace; level <= LogLevelError; level++ {
+		if strings.EqualFold(str, level.String()) {
+			return level, nil
+		}
+	}
+
+	return LogLevelUnspecified, fmt.Errorf("invalid log level %q, use trace, debug, info, warn or error", str)
+}
+
+// LogLevelSetter is optionally implemented by plugins to change the level
+// of their own logger when the host calls SetLogLevel.
+type LogLevelSetter interface {
+	SetLogLevel(level LogLevel) error
+}
@@ -0,0 +1,261 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-plugin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// MetricsRegistry holds the metrics of the process, the host serves it on
+// /metrics in serve mode.
+var MetricsRegistry = prometheus.NewRegistry()
+
+var (
+	clientMetrics = newCallMetrics("client")
+	serverMetrics = newCallMetrics("server")
+
+	logMessagesForwarded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kv_log_messages_forwarded_total",
+		Help: "Plugin log messages received by the host, by level.",
+	}, []string{"level"})
+
+	logMessagesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kv_log_messages_dropped_total",
+		Help: "Plugin log messages not sent to the host, by level and reason (level, error).",
+	}, []string{"level", "reason"})
+)
+
+func init() {
+	MetricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		logMessagesForwarded,
+		logMessagesDropped,
+	)
+
+	clientMetrics.register(MetricsRegistry)
+	serverMetrics.register(MetricsRegistry)
+}
+
+// MetricsDialOptions returns options recording metrics of calls made on a
+// client connection, the host uses them for the plugin connection.
+func MetricsDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(clientMetrics.unaryClient),
+		grpc.WithChainStreamInterceptor(clientMetrics.streamClient),
+	}
+}
+
+// MetricsServerOptions returns options recording metrics of calls handled
+// by a server.
+func MetricsServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(serverMetrics.unaryServer),
+		grpc.ChainStreamInterceptor(serverMetrics.streamServer),
+	}
+}
+
+// PluginGRPCServer is plugin.DefaultGRPCServer recording metrics and traces
+// of the calls handled by GRPCServer, plugins pass it to plugin.Serve.
+func PluginGRPCServer(opts []grpc.ServerOption) *grpc.Server {
+	opts = append(opts, MetricsServerOptions()...)
+	opts = append(opts, TracingServerOptions()...)
+
+	return plugin.DefaultGRPCServer(opts)
+}
+
+// callMetrics are the metrics of gRPC calls on one side of connections.
+type callMetrics struct {
+	calls         *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	requestBytes  *prometheus.HistogramVec
+	responseBytes *prometheus.HistogramVec
+}
+
+func newCallMetrics(side string) *callMetrics {
+	prefix := "kv_grpc_" + side + "_"
+	sizeBuckets := prometheus.ExponentialBuckets(32, 4, 10) // 32B to 8MiB
+
+	return &callMetrics{
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "calls_total",
+			Help: "Completed gRPC calls, by method and status code.",
+		}, []string{"service", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    prefix + "call_duration_seconds",
+			Help:    "Duration of completed gRPC calls.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"service", "method"}),
+		requestBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    prefix + "request_bytes",
+			Help:    "Size of gRPC request messages.",
+			Buckets: sizeBuckets,
+		}, []string{"service", "method"}),
+		responseBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    prefix + "response_bytes",
+			Help:    "Size of gRPC response messages.",
+			Buckets: sizeBuckets,
+		}, []string{"service", "method"}),
+	}
+}
+
+func (m *callMetrics) register(registry *prometheus.Registry) {
+	registry.MustRegister(m.calls, m.duration, m.requestBytes, m.responseBytes)
+}
+
+func (m *callMetrics) done(fullMethod string, start time.Time, err error) {
+	service, method := splitMethod(fullMethod)
+
+	m.calls.WithLabelValues(service, method, status.Code(err).String()).Inc()
+	m.duration.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+}
+
+func (m *callMetrics) message(sizes *prometheus.HistogramVec, fullMethod string, msg interface{}) {
+	if msg, ok := msg.(protobuf.Message); ok {
+		service, method := splitMethod(fullMethod)
+		sizes.WithLabelValues(service, method).Observe(float64(protobuf.Size(msg)))
+	}
+}
+
+func (m *callMetrics) unaryClient(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	m.message(m.requestBytes, method, req)
+
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil {
+		m.message(m.responseBytes, method, reply)
+	}
+
+	m.done(method, start, err)
+
+	return err
+}
+
+func (m *callMetrics) streamClient(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		m.done(method, start, err)
+		return nil, err
+	}
+
+	return &clientStreamMetrics{ClientStream: stream, metrics: m, desc: desc, method: method, start: start}, nil
+}
+
+func (m *callMetrics) unaryServer(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	m.message(m.requestBytes, info.FullMethod, req)
+
+	resp, err := handler(ctx, req)
+	if err == nil {
+		m.message(m.responseBytes, info.FullMethod, resp)
+	}
+
+	m.done(info.FullMethod, start, err)
+
+	return resp, err
+}
+
+func (m *callMetrics) streamServer(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+
+	err := handler(srv, &serverStreamMetrics{ServerStream: stream, metrics: m, method: info.FullMethod})
+	m.done(info.FullMethod, start, err)
+
+	return err
+}
+
+// clientStreamMetrics records message sizes of a client stream, and the
+// call once the stream ends.
+type clientStreamMetrics struct {
+	grpc.ClientStream
+	metrics *callMetrics
+	desc    *grpc.StreamDesc
+	method  string
+	start   time.Time
+	once    sync.Once
+}
+
+func (s *clientStreamMetrics) SendMsg(msg interface{}) error {
+	err := s.ClientStream.SendMsg(msg)
+	if err == nil {
+		s.metrics.message(s.metrics.requestBytes, s.method, msg)
+	}
+
+	return err
+}
+
+func (s *clientStreamMetrics) RecvMsg(msg interface{}) error {
+	err := s.ClientStream.RecvMsg(msg)
+
+	switch {
+	case errors.Is(err, io.EOF):
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	default:
+		s.metrics.message(s.metrics.responseBytes, s.method, msg)
+
+		// the single response of a client streaming call ends it
+		if !s.desc.ServerStreams {
+			s.finish(nil)
+		}
+	}
+
+	return err
+}
+
+func (s *clientStreamMetrics) finish(err error) {
+	s.once.Do(func() {
+		s.metrics.done(s.method, s.start, err)
+	})
+}
+
+// serverStreamMetrics records message sizes of a server stream.
+type serverStreamMetrics struct {
+	grpc.ServerStream
+	metrics *callMetrics
+	method  string
+}
+
+func (s *serverStreamMetrics) SendMsg(msg interface{}) error {
+	err := s.ServerStream.SendMsg(msg)
+	if err == nil {
+		s.metrics.message(s.metrics.responseBytes, s.method, msg)
+	}
+
+	return err
+}
+
+func (s *serverStreamMetrics) RecvMsg(msg interface{}) error {
+	err := s.ServerStream.RecvMsg(msg)
+	if err == nil {
+		s.metrics.message(s.metrics.requestBytes, s.method, msg)
+	}
+
+	return err
+}
+
+// splitMethod splits "/proto.KV/Get" into "proto.KV" and "Get".
+func splitMethod(fullMethod string) (string, string) {
+	service, method, found := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !found {
+		return "unknown", fullMethod
+	}
+
+	return service, method
+}
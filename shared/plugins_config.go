@@ -9,7 +9,12 @@ import (
 )
 
 const (
-	PluginProtocolVersion  = 1
+	PluginProtocolVersionV1 = 1
+	PluginProtocolVersionV2 = 2
+
+	// PluginProtocolVersion is the latest protocol version.
+	PluginProtocolVersion = PluginProtocolVersionV2
+
 	PluginMagicCookieKey   = "BASIC_PLUGIN"
 	PluginMagicCookieValue = "hello"
 	PluginID               = "kv_grpc"
@@ -19,7 +24,7 @@ func PluginHandshakeConfig() plugin.HandshakeConfig {
 	// common handshake that is shared by plugin and host.
 	var handshake = plugin.HandshakeConfig{
 		// This isn't required when using VersionedPlugins
-		ProtocolVersion:  PluginProtocolVersion,
+		ProtocolVersion:  PluginProtocolVersionV1,
 		MagicCookieKey:   PluginMagicCookieKey,
 		MagicCookieValue: PluginMagicCookieValue,
 	}
@@ -44,3 +49,27 @@ func PluginMapServerConfig(kv KV) map[string]plugin.Plugin {
 
 	return pluginMap
 }
+
+// PluginVersionedClientConfig returns plugin sets for every protocol version
+// the host supports. go-plugin negotiates the highest version that both the
+// host and the plugin support.
+func PluginVersionedClientConfig() map[int]plugin.PluginSet {
+	var versionedPlugins = map[int]plugin.PluginSet{
+		PluginProtocolVersionV1: {PluginID: &KVGRPCPlugin{Version: PluginProtocolVersionV1}},
+		PluginProtocolVersionV2: {PluginID: &KVGRPCPlugin{Version: PluginProtocolVersionV2}},
+	}
+
+	return versionedPlugins
+}
+
+// PluginVersionedServerConfig returns plugin sets for a plugin implementing
+// the latest protocol version. Plugins implementing only KV should use
+// PluginMapServerConfig instead.
+func PluginVersionedServerConfig(kv KVv2) map[int]plugin.PluginSet {
+	var versionedPlugins = map[int]plugin.PluginSet{
+		PluginProtocolVersionV1: {PluginID: &KVGRPCPlugin{Impl: kv, Version: PluginProtocolVersionV1}},
+		PluginProtocolVersionV2: {PluginID: &KVGRPCPlugin{Impl: kv, Version: PluginProtocolVersionV2}},
+	}
+
+	return versionedPlugins
+}
@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	zlog "github.com/rs/zerolog/log"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDMetadataKey is the gRPC metadata key carrying the request ID of
+// a KV call, from the host to the plugin and from remote clients to serve.
+const RequestIDMetadataKey = "x-request-id"
+
+// MaxRequestIDLength bounds the request IDs accepted from clients, they end
+// up in every log line of the call.
+const MaxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random request ID of 16 hex characters.
+func NewRequestID() string {
+	id := make([]byte, 8)
+
+	_, err := rand.Read(id)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// ValidRequestID reports whether id can be used as a request ID: printable
+// ASCII, as required of gRPC metadata values, and at most
+// MaxRequestIDLength characters.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ContextWithRequestID returns ctx carrying the request ID id, and a logger
+// tagged with it that zerolog.Ctx returns.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return zlog.Logger.With().Str("request_id", id).Logger().WithContext(ctx)
+}
+
+// RequestIDFromContext returns the request ID of ctx, empty if it has none.
+// Plugins implementing ContextKV find the ID of the host call there.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID returns ctx with a new request ID unless it already has one,
+// along with the ID.
+func WithRequestID(ctx context.Context) (context.Context, string) {
+	id := RequestIDFromContext(ctx)
+	if id != "" {
+		return ctx, id
+	}
+
+	id = NewRequestID()
+
+	return ContextWithRequestID(ctx, id), id
+}
+
+// IncomingRequestContext returns ctx carrying the request ID received in
+// the metadata of a gRPC call, or a new one if there is none or it is not
+// valid.
+func IncomingRequestContext(ctx context.Context) context.Context {
+	values := metadata.ValueFromIncomingContext(ctx, RequestIDMetadataKey)
+	if len(values) > 0 && ValidRequestID(values[0]) {
+		return ContextWithRequestID(ctx, values[0])
+	}
+
+	ctx, _ = WithRequestID(ctx)
+
+	return ctx
+}
+
+// outgoingRequestContext tags the gRPC call made with ctx with its request
+// ID, a new one if it has none.
+func outgoingRequestContext(ctx context.Context) context.Context {
+	ctx, id := WithRequestID(ctx)
+	return metadata.AppendToOutgoingContext(ctx, RequestIDMetadataKey, id)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		valid bool
+	}{
+		{name: "generated", id: NewRequestID(), valid: true},
+		{name: "uuid", id: "0b4f6a8e-2c1d-4e5f-9a7b-3c2d1e0f9a8b", valid: true},
+		{name: "printable", id: "req 1: a=b/c~", valid: true},
+		{name: "max length", id: strings.Repeat("a", MaxRequestIDLength), valid: true},
+		{name: "empty", id: "", valid: false},
+		{name: "too long", id: strings.Repeat("a", MaxRequestIDLength+1), valid: false},
+		{name: "newline", id: "req\nforged log line", valid: false},
+		{name: "tab", id: "req\t1", valid: false},
+		{name: "delete", id: "req\x7f", valid: false},
+		{name: "non ascii", id: "réq", valid: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ValidRequestID(tc.id); got != tc.valid {
+				t.Fatalf("ValidRequestID(%q) = %v, want %v", tc.id, got, tc.valid)
+			}
+		})
+	}
+}
+
+func TestIncomingRequestContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		md     metadata.MD
+		wantID string // empty for a new ID
+	}{
+		{name: "valid id", md: metadata.Pairs(RequestIDMetadataKey, "req-1"), wantID: "req-1"},
+		{name: "no metadata"},
+		{name: "no id", md: metadata.Pairs("other", "value")},
+		{name: "invalid id", md: metadata.Pairs(RequestIDMetadataKey, "req\n1")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+
+			id := RequestIDFromContext(IncomingRequestContext(ctx))
+
+			if tc.wantID != "" && id != tc.wantID {
+				t.Fatalf("got request ID %q, want %q", id, tc.wantID)
+			}
+
+			if tc.wantID == "" && (len(id) != 16 || !ValidRequestID(id)) {
+				t.Fatalf("got request ID %q, want a new one", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"io"
+
+	"github.com/tinybit/go-plugin-log-example/proto"
+)
+
+// snapshotChunkSize is the maximum size of a streamed snapshot chunk, well
+// below the default gRPC message size limit.
+const snapshotChunkSize = 64 * 1024
+
+// snapshotChunkWriter sends written bytes as snapshot chunks.
+type snapshotChunkWriter struct {
+	send func(*proto.SnapshotChunk) error
+}
+
+func (w *snapshotChunkWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		size := min(len(p), snapshotChunkSize)
+
+		err := w.send(&proto.SnapshotChunk{Data: p[:size]})
+		if err != nil {
+			return written, err
+		}
+
+		written += size
+		p = p[size:]
+	}
+
+	return written, nil
+}
+
+// snapshotChunkReader reads the data of received snapshot chunks, it
+// returns io.EOF at the end of the stream.
+type snapshotChunkReader struct {
+	recv func() (*proto.SnapshotChunk, error)
+	data []byte
+}
+
+func (r *snapshotChunkReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		chunk, err := r.recv()
+		if err != nil {
+			return 0, err
+		}
+
+		r.data = chunk.Data
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+// readAll drains the stream, so that the sender sees the end of it.
+func (r *snapshotChunkReader) readAll() error {
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
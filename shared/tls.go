@@ -0,0 +1,209 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	EnvTLSCA   = "KV_TLS_CA"
+	EnvTLSCert = "KV_TLS_CERT"
+	EnvTLSKey  = "KV_TLS_KEY"
+
+	// TLSServerName is the name plugin certificates must be valid for,
+	// go-plugin uses it for AutoMTLS certificates as well.
+	TLSServerName = "localhost"
+)
+
+// TLSFiles points to PEM files of a CA and a certificate signed by it, used
+// for mTLS between host and plugin instead of go-plugin AutoMTLS.
+type TLSFiles struct {
+	CA   string `json:"ca"`
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// TLSFilesFromEnv reads TLS file paths from KV_TLS_* variables, it returns
+// nil if none are set.
+func TLSFilesFromEnv() (*TLSFiles, error) {
+	files := &TLSFiles{
+		CA:   os.Getenv(EnvTLSCA),
+		Cert: os.Getenv(EnvTLSCert),
+		Key:  os.Getenv(EnvTLSKey),
+	}
+
+	if files.CA == "" && files.Cert == "" && files.Key == "" {
+		return nil, nil
+	}
+
+	if files.CA == "" || files.Cert == "" || files.Key == "" {
+		return nil, fmt.Errorf("%s, %s and %s must be set together", EnvTLSCA, EnvTLSCert, EnvTLSKey)
+	}
+
+	return files, nil
+}
+
+// Env returns the files as KV_TLS_* environment entries.
+func (f *TLSFiles) Env() []string {
+	return []string{
+		EnvTLSCA + "=" + f.CA,
+		EnvTLSCert + "=" + f.Cert,
+		EnvTLSKey + "=" + f.Key,
+	}
+}
+
+// Config returns the TLS config used on both sides of every host/plugin
+// connection: the broker makes each side act as a client and as a server,
+// so peers must present a certificate signed by the CA in both directions.
+func (f *TLSFiles) Config() (*tls.Config, error) {
+	cert, pool, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		RootCAs:      pool,
+		ServerName:   TLSServerName,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return config, nil
+}
+
+func (f *TLSFiles) load() (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	caPEM, err := os.ReadFile(f.CA)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to load TLS CA: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return tls.Certificate{}, nil, fmt.Errorf("no certificates found in TLS CA file %s", f.CA)
+	}
+
+	return cert, pool, nil
+}
+
+// PluginTLSProvider returns the plugin TLS config from KV_TLS_* variables
+// passed by the host. It returns a nil config when they are not set, so
+// go-plugin falls back to AutoMTLS.
+func PluginTLSProvider() (*tls.Config, error) {
+	files, err := TLSFilesFromEnv()
+	if err != nil || files == nil {
+		return nil, err
+	}
+
+	return files.Config()
+}
+
+// GenerateTLSFiles creates a private CA and a certificate signed by it in
+// dir, to be shared by the host and its plugins.
+func GenerateTLSFiles(dir string) (*TLSFiles, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	caTemplate, err := certTemplate("kv plugin CA")
+	if err != nil {
+		return nil, err
+	}
+
+	caTemplate.IsCA = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := certTemplate(TLSServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	template.DNSNames = []string{TLSServerName}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	files := &TLSFiles{
+		CA:   filepath.Join(dir, "ca.pem"),
+		Cert: filepath.Join(dir, "cert.pem"),
+		Key:  filepath.Join(dir, "key.pem"),
+	}
+
+	err = errors.Join(
+		writePEM(files.CA, "CERTIFICATE", caDER),
+		writePEM(files.Cert, "CERTIFICATE", certDER),
+		writePEM(files.Key, "EC PRIVATE KEY", keyDER),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func certTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		BasicConstraintsValid: true,
+	}
+
+	return template, nil
+}
+
+func writePEM(path string, blockType string, der []byte) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	return os.WriteFile(path, data, 0600)
+}
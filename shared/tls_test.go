@@ -0,0 +1,289 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+)
+
+// envTestPlugin makes the test binary serve testKV as a plugin instead of
+// running the tests, so that tests can launch it through go-plugin.
+const envTestPlugin = "KV_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(envTestPlugin) != "" {
+		plugin.Serve(&plugin.ServeConfig{
+			HandshakeConfig:  PluginHandshakeConfig(),
+			VersionedPlugins: PluginVersionedServerConfig(&testKV{}),
+			TLSProvider:      PluginTLSProvider,
+			GRPCServer:       PluginGRPCServer,
+			Logger:           hclog.NewNullLogger(),
+		})
+
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// testKV is a plugin logging through the host log broker once it has a
+// logger, so that tests see the broker connection from the plugin work.
+type testKV struct{}
+
+func (testKV) Ping() error                                          { return nil }
+func (testKV) Init(brokerID uint32, config map[string]string) error { return nil }
+func (testKV) Put(key string, value []byte) error                   { return nil }
+func (testKV) Get(key string) ([]byte, error)                       { return nil, ErrNotFound }
+func (testKV) Delete(key string) error                              { return nil }
+
+func (testKV) SetLogger(log LogHelper) error {
+	return log.Log(int(LogLevelInfo), "connected to the host log broker")
+}
+
+// chanLogHelper sends the logged messages to a channel.
+type chanLogHelper chan string
+
+func (c chanLogHelper) Log(level int, msg string) error {
+	c <- msg
+	return nil
+}
+
+// startTestPlugin launches the test binary as a plugin given the pluginFiles
+// TLS files, the host using hostFiles or AutoMTLS if nil, and initializes
+// it, which makes the plugin connect back to the host log broker.
+func startTestPlugin(t *testing.T, hostFiles *TLSFiles, pluginFiles *TLSFiles) (chanLogHelper, error) {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = []string{envTestPlugin + "=1"}
+
+	config := &plugin.ClientConfig{
+		HandshakeConfig:  PluginHandshakeConfig(),
+		VersionedPlugins: PluginVersionedClientConfig(),
+		Cmd:              cmd,
+		SkipHostEnv:      true,
+		AutoMTLS:         hostFiles == nil,
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Logger:           hclog.NewNullLogger(),
+	}
+
+	if hostFiles != nil {
+		tlsConfig, err := hostFiles.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config.TLSConfig = tlsConfig
+	}
+
+	if pluginFiles != nil {
+		cmd.Env = append(cmd.Env, pluginFiles.Env()...)
+	}
+
+	client := plugin.NewClient(config)
+	t.Cleanup(client.Kill)
+
+	rpcClient, err := client.Client()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := rpcClient.Dispense(PluginID)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := raw.(*GRPCClient)
+
+	err = kv.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make(chanLogHelper, 1)
+	kv.SetLogger(logs)
+
+	return logs, kv.Initialize()
+}
+
+func TestPluginConnectionTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	files, err := GenerateTLSFiles(filepath.Join(dir, "plugin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impostor, err := GenerateTLSFiles(filepath.Join(dir, "impostor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		hostFiles   *TLSFiles
+		pluginFiles *TLSFiles
+		wantErr     bool
+	}{
+		{name: "AutoMTLS"},
+		{name: "same CA", hostFiles: files, pluginFiles: files},
+		{name: "plugin certificate from another CA", hostFiles: files, pluginFiles: impostor, wantErr: true},
+		{name: "host certificate from another CA", hostFiles: impostor, pluginFiles: files, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := startTestPlugin(t, tt.hostFiles, tt.pluginFiles)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("plugin connection with mismatched certificates succeeded")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("plugin connection failed: %v", err)
+			}
+
+			select {
+			case <-logs:
+			case <-time.After(10 * time.Second):
+				t.Fatal("plugin did not log through the host log broker")
+			}
+		})
+	}
+}
+
+// servePluginTLS accepts connections with the plugin TLS config and sends
+// the result of each server side handshake.
+func servePluginTLS(t *testing.T, files *TLSFiles) (string, <-chan error) {
+	t.Setenv(EnvTLSCA, files.CA)
+	t.Setenv(EnvTLSCert, files.Cert)
+	t.Setenv(EnvTLSKey, files.Key)
+
+	config, err := PluginTLSProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config == nil {
+		t.Fatal("PluginTLSProvider returned no config with KV_TLS_* set")
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	handshakes := make(chan error, 1)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			err = conn.(*tls.Conn).Handshake()
+			if err == nil {
+				_, err = conn.Write([]byte("ok"))
+			}
+
+			conn.Close()
+			handshakes <- err
+		}
+	}()
+
+	return l.Addr().String(), handshakes
+}
+
+// dialPluginTLS connects to addr with a client certificate from files,
+// trusting the server certificates signed by serverCA.
+func dialPluginTLS(t *testing.T, addr string, files *TLSFiles, serverCA string) error {
+	config, err := files.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPEM, err := os.ReadFile(serverCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.RootCAs = x509.NewCertPool()
+	config.RootCAs.AppendCertsFromPEM(caPEM)
+
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// with TLS 1.3 the server verifies the client certificate after the
+	// client handshake completed, its alert is only seen on read
+	buf := make([]byte, 2)
+
+	_, err = conn.Read(buf)
+
+	return err
+}
+
+func TestPluginTLSRejectsOtherCA(t *testing.T) {
+	dir := t.TempDir()
+
+	files, err := GenerateTLSFiles(filepath.Join(dir, "plugin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impostor, err := GenerateTLSFiles(filepath.Join(dir, "impostor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, handshakes := servePluginTLS(t, files)
+
+	err = dialPluginTLS(t, addr, impostor, files.CA)
+	if err == nil {
+		t.Fatal("client with a certificate signed by another CA connected")
+	}
+
+	if err := <-handshakes; err == nil {
+		t.Fatal("server accepted a client certificate signed by another CA")
+	}
+
+	err = dialPluginTLS(t, addr, files, files.CA)
+	if err != nil {
+		t.Fatalf("client with a certificate signed by the plugin CA failed to connect: %v", err)
+	}
+
+	if err := <-handshakes; err != nil {
+		t.Fatalf("server rejected a client certificate signed by the plugin CA: %v", err)
+	}
+}
+
+func TestPluginTLSProviderWithoutFiles(t *testing.T) {
+	t.Setenv(EnvTLSCA, "")
+	t.Setenv(EnvTLSCert, "")
+	t.Setenv(EnvTLSKey, "")
+
+	config, err := PluginTLSProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config != nil {
+		t.Fatal("PluginTLSProvider returned a config without KV_TLS_* set, go-plugin AutoMTLS would be disabled")
+	}
+}
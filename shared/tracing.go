@@ -0,0 +1,202 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package shared
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+const (
+	EnvTraceExporter = "KV_TRACE_EXPORTER"
+	EnvTraceEndpoint = "KV_TRACE_ENDPOINT"
+	EnvTraceFile     = "KV_TRACE_FILE"
+
+	TraceExporterNone = "none"
+	TraceExporterOTLP = "otlp"
+	TraceExporterFile = "file"
+
+	// tracedService is the only gRPC service traced, go-plugin's own
+	// services (broker, stdio, controller) would only add noise.
+	tracedService = "proto.KV"
+
+	tracerName = "github.com/tinybit/go-plugin-log-example"
+)
+
+// TracingConfig selects where the spans of a process are exported. The host
+// passes its config to plugins in their environment, so that both ends of
+// a call export to the same place.
+type TracingConfig struct {
+	Exporter string `json:"exporter"`
+	Endpoint string `json:"endpoint"` // OTLP gRPC host:port, plaintext
+	File     string `json:"file"`     // JSON spans are appended to it
+}
+
+// TracingConfigFromEnv reads the tracing config from KV_TRACE_* variables
+// looked up with getenv, it returns nil if tracing is disabled.
+func TracingConfigFromEnv(getenv func(string) string) (*TracingConfig, error) {
+	config := &TracingConfig{
+		Exporter: getenv(EnvTraceExporter),
+		Endpoint: getenv(EnvTraceEndpoint),
+		File:     getenv(EnvTraceFile),
+	}
+
+	switch config.Exporter {
+	case "", TraceExporterNone:
+		return nil, nil
+	case TraceExporterOTLP:
+		if config.Endpoint == "" {
+			config.Endpoint = "localhost:4317"
+		}
+	case TraceExporterFile:
+		if config.File == "" {
+			return nil, fmt.Errorf("%s must be set with %s=%s", EnvTraceFile, EnvTraceExporter, TraceExporterFile)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s value %q, expected none, otlp or file", EnvTraceExporter, config.Exporter)
+	}
+
+	return config, nil
+}
+
+// Env returns the config as KV_TRACE_* environment entries.
+func (c *TracingConfig) Env() []string {
+	return []string{
+		EnvTraceExporter + "=" + c.Exporter,
+		EnvTraceEndpoint + "=" + c.Endpoint,
+		EnvTraceFile + "=" + c.File,
+	}
+}
+
+// ConfigureTracing installs the W3C trace context propagator and, unless
+// config is nil, a tracer provider exporting the spans of service. The
+// returned function flushes and stops the exporter.
+func ConfigureTracing(config *TracingConfig, service string) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if config == nil {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	var processor sdktrace.SpanProcessor
+
+	switch config.Exporter {
+	case TraceExporterOTLP:
+		exporter, err := otlptracegrpc.New(context.Background(),
+			otlptracegrpc.WithEndpoint(config.Endpoint),
+			otlptracegrpc.WithInsecure(),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+
+	case TraceExporterFile:
+		// the host and its plugins append to the same file
+		file, err := os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		// write spans as they end, plugin processes may be killed any time
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// flushTracing exports the spans ended so far, if a tracer provider was
+// configured.
+func flushTracing(ctx context.Context) error {
+	if provider, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		return provider.ForceFlush(ctx)
+	}
+
+	return nil
+}
+
+// Tracer returns the tracer of kv spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// TracingDialOptions returns options tracing KV calls made on a client
+// connection and propagating their trace context in gRPC metadata.
+func TracingDialOptions() []grpc.DialOption {
+	filter := otelgrpc.WithInterceptorFilter(filters.ServiceName(tracedService))
+
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor(filter)),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor(filter)),
+	}
+}
+
+// TracingServerOptions returns options tracing KV calls handled by a server,
+// as children of the trace context found in their metadata.
+func TracingServerOptions() []grpc.ServerOption {
+	filter := otelgrpc.WithInterceptorFilter(filters.ServiceName(tracedService))
+
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(filter)),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(filter)),
+	}
+}
+
+// spanIDs returns the hex trace and span IDs of the span in ctx, empty if
+// there is none.
+func spanIDs(ctx context.Context) (traceID string, spanID string) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", ""
+	}
+
+	return sc.TraceID().String(), sc.SpanID().String()
+}
+
+// contextWithSpanIDs returns ctx with the remote span identified by hex
+// trace and span IDs, ctx itself if they are not valid.
+func contextWithSpanIDs(ctx context.Context, traceID string, spanID string) context.Context {
+	tid, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		return ctx
+	}
+
+	sid, err := trace.SpanIDFromHex(spanID)
+	if err != nil {
+		return ctx
+	}
+
+	return trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: tid,
+		SpanID:  sid,
+		Remote:  true,
+	}))
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+const (
+	EnvShellHistory      = "KV_SHELL_HISTORY"
+	DefaultShellHistory  = ".kv_history" // in the home directory
+	defaultWatchInterval = time.Second
+)
+
+// shellCommands lists the shell commands with their help text.
+var shellCommands = []struct {
+	name string
+	help string
+}{
+	{"get", "get <key>                 print the value of a key"},
+	{"put", "put <key> <value>         set a key, the value is the rest of the line"},
+	{"delete", "delete <key>              delete a key"},
+	{"list", "list [prefix]             list keys"},
+	{"watch", "watch <key> [interval]    print the value on every change until Ctrl-C"},
+	{"help", "help                      show this help"},
+	{"exit", "exit                      leave the shell (or Ctrl-D)"},
+}
+
+// shell runs an interactive session against a single plugin connection,
+// printing the time taken by every command.
+func (c *cli) shell(args []string) error {
+	editor := NewLineEditor()
+	editor.Complete = c.completeShell
+
+	historyPath := c.shellHistoryPath()
+	if historyPath != "" {
+		err := editor.LoadHistory(historyPath)
+		if err != nil {
+			zlog.Warn().Err(err).Str("path", historyPath).Msg("Failed to load shell history.")
+		}
+
+		defer func() {
+			err := editor.SaveHistory(historyPath)
+			if err != nil {
+				zlog.Warn().Err(err).Str("path", historyPath).Msg("Failed to save shell history.")
+			}
+		}()
+	}
+
+	// Ctrl-C stops the running command instead of the shell
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	if editor.Terminal() {
+		fmt.Fprintf(os.Stderr, "Connected to store %q, type help for commands.\n", c.host.store)
+	}
+
+	prompt := "kv:" + c.host.store + "> "
+
+	for {
+		line, err := editor.ReadLine(prompt)
+		if errors.Is(err, ErrInterrupted) {
+			continue
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name, rest := cutWord(line)
+		if name == "" {
+			continue
+		}
+
+		if name == "exit" || name == "quit" {
+			return nil
+		}
+
+		// drop interrupts received while editing
+		select {
+		case <-interrupts:
+		default:
+		}
+
+		start := time.Now()
+		err = c.runShellCommand(name, rest, interrupts)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "(%v)\n", time.Since(start).Round(time.Microsecond))
+	}
+}
+
+func (c *cli) runShellCommand(name string, rest string, interrupts <-chan os.Signal) error {
+	key, value := cutWord(rest)
+
+	switch name {
+	case "get":
+		if key == "" {
+			return errors.New("usage: get <key>")
+		}
+
+		return c.shellValue(key)
+
+	case "put":
+		if key == "" {
+			return errors.New("usage: put <key> <value>")
+		}
+
+		return c.kv.Put(key, []byte(value))
+
+	case "delete":
+		if key == "" {
+			return errors.New("usage: delete <key>")
+		}
+
+		return c.kv.Delete(key)
+
+	case "list":
+		keys, err := c.kv.List(key)
+		if err != nil {
+			return err
+		}
+
+		return c.output.Keys(keys)
+
+	case "watch":
+		if key == "" {
+			return errors.New("usage: watch <key> [interval]")
+		}
+
+		interval := defaultWatchInterval
+		if value != "" {
+			var err error
+
+			interval, err = time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return fmt.Errorf("invalid interval %q", value)
+			}
+		}
+
+		return c.watchKey(key, interval, interrupts)
+
+	case "help":
+		for _, cmd := range shellCommands {
+			fmt.Fprintln(os.Stderr, cmd.help)
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unknown command %q, type help for commands", name)
+	}
+}
+
+// shellValue writes a value, raw values get a trailing newline so that the
+// prompt starts on its own line.
+func (c *cli) shellValue(key string) error {
+	value, contentType, err := c.kv.GetWithContentType(key)
+	if err != nil {
+		return err
+	}
+
+	err = c.output.Value(key, value, contentType)
+	if err != nil {
+		return err
+	}
+
+	if c.output.format == OutputRaw && !bytes.HasSuffix(value, []byte("\n")) {
+		fmt.Fprintln(c.output.w)
+	}
+
+	return nil
+}
+
+// watchKey polls key and prints its value whenever it changes, until an
+// interrupt is received.
+func (c *cli) watchKey(key string, interval time.Duration, interrupts <-chan os.Signal) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []byte
+	found := false
+	first := true
+
+	for {
+		value, err := c.kv.Get(key)
+
+		switch {
+		case errors.Is(err, shared.ErrNotFound):
+			if first || found {
+				fmt.Fprintf(os.Stderr, "%s: not found\n", key)
+			}
+
+			found = false
+
+		case err != nil:
+			return err
+
+		default:
+			if first || !found || !bytes.Equal(value, last) {
+				err = c.shellValue(key)
+				if err != nil {
+					return err
+				}
+			}
+
+			last, found = value, true
+		}
+
+		first = false
+
+		select {
+		case <-interrupts:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// completeShell completes command names and, for their key argument, keys
+// starting with the typed word.
+func (c *cli) completeShell(line string, word string) []string {
+	candidates := []string{}
+	fields := strings.Fields(line)
+
+	switch {
+	case len(fields) == 0:
+		for _, cmd := range shellCommands {
+			if strings.HasPrefix(cmd.name, word) {
+				candidates = append(candidates, cmd.name)
+			}
+		}
+
+	case len(fields) == 1 && fields[0] != "help" && fields[0] != "exit":
+		keys, err := c.kv.List(word)
+		if err == nil {
+			candidates = keys
+		}
+	}
+
+	return candidates
+}
+
+func (c *cli) shellHistoryPath() string {
+	if path := c.settings.Get(EnvShellHistory); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, DefaultShellHistory)
+}
+
+// cutWord splits line into its first word and the rest.
+func cutWord(line string) (string, string) {
+	line = strings.TrimLeft(line, " \t")
+
+	word, rest, _ := strings.Cut(line, " ")
+
+	return word, strings.TrimLeft(rest, " \t")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// snapshotFileMagic starts snapshot files. The plugin snapshot follows,
+// then the SHA-256 checksum of everything before it.
+var snapshotFileMagic = []byte("KVSNAP\x00\x01")
+
+var ErrSnapshotChecksum = errors.New("snapshot file checksum mismatch")
+
+// WriteSnapshotFile writes the snapshot written by snapshot to path along
+// with its checksum. The file is renamed into place once complete, so path
+// never holds a partial snapshot. It returns the checksum.
+func WriteSnapshotFile(path string, snapshot func(w io.Writer) error) (string, error) {
+	var sum []byte
+
+	err := writeFileAtomic(path, func(out io.Writer) error {
+		hash := sha256.New()
+		w := io.MultiWriter(out, hash)
+
+		_, err := w.Write(snapshotFileMagic)
+		if err != nil {
+			return err
+		}
+
+		err = snapshot(w)
+		if err != nil {
+			return err
+		}
+
+		sum = hash.Sum(nil)
+
+		_, err = out.Write(sum)
+
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sum), nil
+}
+
+// writeFileAtomic writes a temporary file next to path with write, fsyncs
+// it and renames it to path, so that path keeps its previous content if
+// write fails.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		file.Close()
+		os.Remove(file.Name()) // fails once renamed
+	}()
+
+	bw := bufio.NewWriter(file)
+
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if err == nil {
+		err = file.Close()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// ReadSnapshotFile passes the plugin snapshot of the snapshot file at path
+// to restore, verifying its checksum as it is read. On a mismatch the
+// reader fails instead of reaching the end of the snapshot, so the plugin
+// never applies it. It returns the checksum.
+func ReadSnapshotFile(path string, restore func(r io.Reader) error) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	size := info.Size() - sha256.Size
+	if size < int64(len(snapshotFileMagic)) {
+		return "", fmt.Errorf("%s is not a snapshot file", path)
+	}
+
+	magic := make([]byte, len(snapshotFileMagic))
+
+	_, err = io.ReadFull(file, magic)
+	if err != nil {
+		return "", err
+	}
+
+	if !bytes.Equal(magic, snapshotFileMagic) {
+		return "", fmt.Errorf("%s is not a snapshot file", path)
+	}
+
+	// the checksum is verified on the bytes sent, read once from the same
+	// file handle, so that changes to the file while restoring are caught
+	r := &checksumReader{
+		path:    path,
+		file:    file,
+		payload: io.LimitReader(file, size-int64(len(snapshotFileMagic))),
+		hash:    sha256.New(),
+	}
+
+	r.hash.Write(magic)
+
+	err = restore(bufio.NewReader(r))
+	if err != nil {
+		return "", err
+	}
+
+	if r.sum == nil {
+		return "", fmt.Errorf("%s was not read completely", path)
+	}
+
+	return hex.EncodeToString(r.sum), nil
+}
+
+// checksumReader reads the plugin snapshot of a snapshot file while hashing
+// it, and returns ErrSnapshotChecksum instead of io.EOF if the checksum
+// following it doesn't match.
+type checksumReader struct {
+	path    string
+	file    *os.File
+	payload io.Reader
+	hash    hash.Hash
+	sum     []byte // set once verified
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.payload.Read(p)
+	r.hash.Write(p[:n])
+
+	if errors.Is(err, io.EOF) && r.sum == nil {
+		sum := make([]byte, sha256.Size)
+
+		_, err = io.ReadFull(r.file, sum)
+		if err != nil {
+			return n, err
+		}
+
+		if !bytes.Equal(sum, r.hash.Sum(nil)) {
+			return n, fmt.Errorf("%w: %s", ErrSnapshotChecksum, r.path)
+		}
+
+		r.sum = sum
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (c *cli) snapshot(args []string) error {
+	sum, err := WriteSnapshotFile(args[0], c.kv.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	zlog.Info().Str("path", args[0]).Str("sha256", sum).Msg("Wrote snapshot.")
+
+	return nil
+}
+
+func (c *cli) restore(args []string) error {
+	sum, err := ReadSnapshotFile(args[0], c.kv.Restore)
+	if err != nil {
+		return err
+	}
+
+	zlog.Info().Str("path", args[0]).Str("sha256", sum).Msg("Restored snapshot.")
+
+	return nil
+}
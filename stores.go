@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	EnvStores        = "KV_STORES"
+	DefaultStoreName = "default"
+)
+
+var storeNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// StoreConfig is a named plugin instance. Several stores may run the same
+// plugin binary with different arguments, environment or working dir.
+type StoreConfig struct {
+	Name string
+	Spec *PluginSpec
+}
+
+// StoresFromEnv returns the stores listed in KV_STORES. Each store reads its
+// plugin spec from KV_STORE_<NAME>_PLUGIN* variables, falling back to the
+// KV_PLUGIN* ones. Without KV_STORES there is a single "default" store.
+// Variables are looked up with getenv, see Settings.
+func StoresFromEnv(getenv func(string) string) ([]*StoreConfig, error) {
+	names := splitList(getenv(EnvStores))
+	if len(names) == 0 {
+		names = []string{DefaultStoreName}
+	}
+
+	stores := []*StoreConfig{}
+	seen := map[string]bool{}
+
+	for _, name := range names {
+		if !storeNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid store name %q in %s", name, EnvStores)
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate store name %q in %s", name, EnvStores)
+		}
+
+		seen[name] = true
+
+		spec, err := pluginSpecFromEnv(storeGetenv(getenv, name))
+		if err != nil {
+			return nil, fmt.Errorf("store %q: %v", name, err)
+		}
+
+		stores = append(stores, &StoreConfig{Name: name, Spec: spec})
+	}
+
+	return stores, nil
+}
+
+// storeGetenv looks up KV_STORE_<NAME>_* variables before KV_* ones.
+func storeGetenv(getenv func(string) string, name string) func(string) string {
+	return func(key string) string {
+		if value := getenv(storeEnvPrefix(name) + strings.TrimPrefix(key, "KV_")); value != "" {
+			return value
+		}
+
+		return getenv(key)
+	}
+}
+
+func storeEnvPrefix(name string) string {
+	return "KV_STORE_" + strings.ToUpper(name) + "_"
+}
+
+// FindStore returns the store with the given name, or the first store if
+// name is empty.
+func FindStore(stores []*StoreConfig, name string) (*StoreConfig, error) {
+	if name == "" {
+		return stores[0], nil
+	}
+
+	for _, store := range stores {
+		if store.Name == name {
+			return store, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown store %q, configure it in %s", name, EnvStores)
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-plugin"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/shared"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrSupervisorStopped = errors.New("plugin supervisor is stopped")
+
+	// ErrSupervisorFailed is returned once the supervisor gave up
+	// restarting the plugin, it wraps the reason.
+	ErrSupervisorFailed = errors.New("plugin can't be restarted")
+)
+
+type SupervisorOptions struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxRestarts limits consecutive failed restarts, 0 means unlimited.
+	MaxRestarts int
+
+	// CheckInterval is how often the plugin process is checked for exit.
+	CheckInterval time.Duration
+
+	// ShutdownTimeout limits the graceful Shutdown call made before the
+	// plugin process is killed.
+	ShutdownTimeout time.Duration
+
+	// RetryIdempotent retries idempotent calls (Get, List, Capabilities) once
+	// after the plugin has been restarted.
+	RetryIdempotent bool
+
+	// Attached means the plugin process is owned by another host process
+	// (reattach mode), so it is neither restarted, shut down nor killed.
+	Attached bool
+
+	// LogHelper serves log calls of the plugin, shared.MainLogHelper is
+	// used if nil.
+	LogHelper shared.LogHelper
+
+	// HostConfig holds the plugin settings passed at every Init, it is also
+	// served to the plugin over the broker. Nil means no settings.
+	HostConfig *PluginSettings
+
+	// Metrics receives the metrics reported by the plugin, nil means they
+	// are not collected.
+	Metrics shared.MetricsReceiver
+
+	// OnLaunch is called every time the plugin has been (re)started.
+	OnLaunch func(client *plugin.Client)
+
+	// LogLevel returns the plugin log level set at runtime by another
+	// process, if any. It is applied to launched plugins unless the level
+	// was set through the supervisor.
+	LogLevel func() (shared.LogLevel, bool)
+}
+
+func DefaultSupervisorOptions() SupervisorOptions {
+	return SupervisorOptions{
+		MinBackoff:      100 * time.Millisecond,
+		MaxBackoff:      30 * time.Second,
+		CheckInterval:   500 * time.Millisecond,
+		ShutdownTimeout: 5 * time.Second,
+		RetryIdempotent: true,
+	}
+}
+
+// Supervisor owns the plugin process: it launches it, performs Ping and
+// Initialize, and restarts it with backoff when the process exits.
+type Supervisor struct {
+	spec      *PluginSpec
+	newConfig func(spec *PluginSpec) (*plugin.ClientConfig, error)
+	opts      SupervisorOptions
+
+	mutex     sync.Mutex
+	client    *plugin.Client
+	kv        *shared.GRPCClient
+	restarted chan struct{} // closed when a restart completes
+	stopped   bool
+	failed    error // why the plugin can't be restarted
+	cancel    context.CancelFunc
+	logLevel  *shared.LogLevel // set at runtime, applied to launched plugins
+}
+
+func NewSupervisor(spec *PluginSpec, newConfig func(spec *PluginSpec) (*plugin.ClientConfig, error), opts SupervisorOptions) *Supervisor {
+	return &Supervisor{
+		spec:      spec,
+		newConfig: newConfig,
+		opts:      opts,
+		restarted: make(chan struct{}),
+	}
+}
+
+// Start launches the plugin and starts watching it in the background.
+func (s *Supervisor) Start() error {
+	err := s.launch()
+	if err != nil {
+		return err
+	}
+
+	if s.opts.Attached {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopped {
+		cancel()
+		return ErrSupervisorStopped
+	}
+
+	s.cancel = cancel
+
+	go s.watch(ctx)
+
+	return nil
+}
+
+// Stop gracefully shuts the plugin down, kills it and stops restarting it.
+// The Shutdown call is made without holding the mutex, so that a hung
+// plugin doesn't block other callers, which fail with ErrSupervisorStopped.
+func (s *Supervisor) Stop() {
+	s.mutex.Lock()
+
+	s.stopped = true
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	client, kv := s.client, s.kv
+
+	s.mutex.Unlock()
+
+	if client == nil || s.opts.Attached {
+		return
+	}
+
+	if !client.Exited() {
+		err := kv.Shutdown(s.opts.ShutdownTimeout)
+		if err != nil && !errors.Is(err, shared.ErrUnsupported) {
+			zlog.Warn().Err(err).Msg("Plugin graceful shutdown failed.")
+		}
+	}
+
+	client.Kill()
+}
+
+// Client returns the current plugin client.
+func (s *Supervisor) Client() (*shared.GRPCClient, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopped {
+		return nil, ErrSupervisorStopped
+	}
+
+	if s.failed != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSupervisorFailed, s.failed)
+	}
+
+	return s.kv, nil
+}
+
+// Attached reports whether the plugin process is owned by another host
+// process.
+func (s *Supervisor) Attached() bool {
+	return s.opts.Attached
+}
+
+// PluginClient returns the current go-plugin client.
+func (s *Supervisor) PluginClient() *plugin.Client {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.client
+}
+
+func (s *Supervisor) launch() error {
+	config, err := s.newConfig(s.spec)
+	if err != nil {
+		return err
+	}
+
+	client := plugin.NewClient(config)
+
+	kv, err := s.connect(client)
+	if err != nil {
+		// never kill a plugin owned by another host process
+		if !s.opts.Attached {
+			client.Kill()
+		}
+
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopped {
+		client.Kill()
+		return ErrSupervisorStopped
+	}
+
+	// release the connections and log goroutines of the exited client
+	if s.client != nil && !s.opts.Attached {
+		s.client.Kill()
+	}
+
+	s.client = client
+	s.kv = kv
+
+	if s.opts.OnLaunch != nil {
+		s.opts.OnLaunch(client)
+	}
+
+	return nil
+}
+
+func (s *Supervisor) connect(client *plugin.Client) (*shared.GRPCClient, error) {
+	// Start the plugin process and apply resource limits to it
+	_, err := client.Start()
+	if errors.Is(err, plugin.ErrChecksumsDoNotMatch) {
+		zlog.Error().Str("path", s.spec.Path).Msg("Plugin binary does not match pinned checksum, refusing to launch.")
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	pid, err := strconv.Atoi(client.ID())
+	if err != nil {
+		return nil, fmt.Errorf("unexpected plugin process id %q: %v", client.ID(), err)
+	}
+
+	if s.opts.Attached {
+		zlog.Info().Int("pid", pid).Str("path", s.spec.Path).Msg("Reattached to plugin process.")
+	} else {
+		zlog.Info().Int("pid", pid).Str("path", s.spec.Path).Msg("Plugin process started.")
+	}
+
+	// Connect via RPC
+	rpcClient, err := client.Client()
+	if err != nil {
+		return nil, err
+	}
+
+	// Request the plugin
+	raw, err := rpcClient.Dispense(shared.PluginID)
+	if err != nil {
+		return nil, err
+	}
+
+	// We should have a KV store now! This feels like a normal interface
+	// implementation but is in fact over an RPC connection.
+	kv := raw.(*shared.GRPCClient)
+
+	zlog.Info().Int("protocol_version", kv.Version()).Msg("Negotiated plugin protocol version.")
+
+	// ping first
+	err = kv.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	// An attached plugin was initialized by its owner and keeps logging
+	// through the owner's log broker server.
+	if s.opts.Attached {
+		return kv, nil
+	}
+
+	if s.opts.LogHelper != nil {
+		kv.SetLogger(s.opts.LogHelper)
+	}
+
+	if s.opts.HostConfig != nil {
+		kv.SetConfig(s.opts.HostConfig.Values())
+		kv.SetHostConfig(s.opts.HostConfig)
+	}
+
+	if s.opts.Metrics != nil {
+		kv.SetMetrics(s.opts.Metrics)
+	}
+
+	// init plugin, this also starts the log broker server for it
+	err = kv.Initialize()
+	if errors.Is(err, shared.ErrInvalidConfig) {
+		zlog.Error().Err(err).Str("path", s.spec.Path).Msg("Plugin rejected its config.")
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if level, ok := s.runtimeLogLevel(); ok {
+		err = kv.SetLogLevel(level)
+		if err != nil && !errors.Is(err, shared.ErrUnsupported) {
+			return nil, err
+		}
+	}
+
+	return kv, nil
+}
+
+// runtimeLogLevel returns the plugin log level changed after start, if any.
+func (s *Supervisor) runtimeLogLevel() (shared.LogLevel, bool) {
+	s.mutex.Lock()
+	level := s.logLevel
+	s.mutex.Unlock()
+
+	if level != nil {
+		return *level, true
+	}
+
+	if s.opts.LogLevel != nil {
+		return s.opts.LogLevel()
+	}
+
+	return 0, false
+}
+
+func (s *Supervisor) watch(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !s.PluginClient().Exited() {
+			continue
+		}
+
+		zlog.Warn().Str("path", s.spec.Path).Msg("Plugin process exited, restarting.")
+
+		if !s.restart(ctx) {
+			return
+		}
+	}
+}
+
+// restart relaunches the plugin with exponential backoff. It returns false
+// if the supervisor was stopped or gave up.
+func (s *Supervisor) restart(ctx context.Context) bool {
+	backoff := s.opts.MinBackoff
+
+	var lastErr error
+
+	for attempt := 1; s.opts.MaxRestarts == 0 || attempt <= s.opts.MaxRestarts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+
+		err := s.launch()
+		if err == nil {
+			zlog.Info().Int("attempt", attempt).Msg("Plugin restarted.")
+			s.notifyRestarted()
+			return true
+		}
+
+		if errors.Is(err, ErrSupervisorStopped) {
+			return false
+		}
+
+		// retrying can't fix the config or the binary
+		if errors.Is(err, shared.ErrInvalidConfig) || errors.Is(err, plugin.ErrChecksumsDoNotMatch) {
+			zlog.Error().Err(err).Int("attempt", attempt).Msg("Plugin can't be restarted, giving up.")
+			s.fail(err)
+			return false
+		}
+
+		zlog.Error().Err(err).Int("attempt", attempt).Dur("backoff", backoff).Msg("Failed to restart plugin.")
+		lastErr = err
+
+		backoff *= 2
+		if backoff > s.opts.MaxBackoff {
+			backoff = s.opts.MaxBackoff
+		}
+	}
+
+	zlog.Error().Err(lastErr).Int("max_restarts", s.opts.MaxRestarts).Msg("Giving up restarting plugin.")
+	s.fail(fmt.Errorf("gave up after %d failed restarts: %w", s.opts.MaxRestarts, lastErr))
+
+	return false
+}
+
+// fail makes calls return err instead of the client of the exited plugin,
+// and wakes up the calls waiting for a restart so that they return it too.
+func (s *Supervisor) fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failed = err
+
+	close(s.restarted)
+	s.restarted = make(chan struct{})
+}
+
+func (s *Supervisor) notifyRestarted() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	close(s.restarted)
+	s.restarted = make(chan struct{})
+}
+
+// waitRestart blocks until the restart signalled by restarted completes or
+// timeout expires.
+func waitRestart(restarted <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-restarted:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// retry runs an idempotent call once more after a plugin restart if the
+// first attempt failed because the plugin was unavailable. Both attempts
+// share the request ID of ctx, if any.
+func (s *Supervisor) retry(ctx context.Context, call func(kv *shared.GRPCClient) error) error {
+	s.mutex.Lock()
+	restarted := s.restarted
+	s.mutex.Unlock()
+
+	kv, err := s.Client()
+	if err != nil {
+		return err
+	}
+
+	err = call(kv)
+	if err == nil || !s.opts.RetryIdempotent || status.Code(err) != codes.Unavailable {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Warn().Err(err).Msg("Plugin unavailable, retrying call after restart.")
+
+	if !waitRestart(restarted, s.opts.MaxBackoff+s.opts.CheckInterval) {
+		return err
+	}
+
+	kv, err = s.Client()
+	if err != nil {
+		return err
+	}
+
+	return call(kv)
+}
+
+func (s *Supervisor) Get(key string) ([]byte, error) {
+	value, _, err := s.GetWithContentType(key)
+	return value, err
+}
+
+func (s *Supervisor) GetWithContentType(key string) ([]byte, string, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+// GetContext is GetWithContentType for a call traced by ctx.
+func (s *Supervisor) GetContext(ctx context.Context, key string) ([]byte, string, error) {
+	ctx, _ = shared.WithRequestID(ctx)
+
+	var value []byte
+	var contentType string
+
+	err := s.retry(ctx, func(kv *shared.GRPCClient) (err error) {
+		value, contentType, err = kv.GetContext(ctx, key)
+		return
+	})
+
+	return value, contentType, err
+}
+
+func (s *Supervisor) List(prefix string) ([]string, error) {
+	return s.ListContext(context.Background(), prefix)
+}
+
+// ListContext is List for a call traced by ctx.
+func (s *Supervisor) ListContext(ctx context.Context, prefix string) ([]string, error) {
+	ctx, _ = shared.WithRequestID(ctx)
+
+	var keys []string
+
+	err := s.retry(ctx, func(kv *shared.GRPCClient) (err error) {
+		keys, err = kv.ListContext(ctx, prefix)
+		return
+	})
+
+	return keys, err
+}
+
+func (s *Supervisor) Capabilities() (shared.Capabilities, error) {
+	var caps shared.Capabilities
+
+	err := s.retry(context.Background(), func(kv *shared.GRPCClient) (err error) {
+		caps, err = kv.Capabilities()
+		return
+	})
+
+	return caps, err
+}
+
+func (s *Supervisor) Put(key string, value []byte) error {
+	return s.PutWithContentType(key, value, "")
+}
+
+func (s *Supervisor) PutWithContentType(key string, value []byte, contentType string) error {
+	return s.PutContext(context.Background(), key, value, contentType)
+}
+
+// PutContext is PutWithContentType for a call traced by ctx.
+func (s *Supervisor) PutContext(ctx context.Context, key string, value []byte, contentType string) error {
+	kv, err := s.Client()
+	if err != nil {
+		return err
+	}
+
+	return kv.PutContext(ctx, key, value, contentType)
+}
+
+func (s *Supervisor) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete for a call traced by ctx.
+func (s *Supervisor) DeleteContext(ctx context.Context, key string) error {
+	kv, err := s.Client()
+	if err != nil {
+		return err
+	}
+
+	return kv.DeleteContext(ctx, key)
+}
+
+func (s *Supervisor) Snapshot(w io.Writer) error {
+	kv, err := s.Client()
+	if err != nil {
+		return err
+	}
+
+	return kv.Snapshot(w)
+}
+
+func (s *Supervisor) Restore(r io.Reader) error {
+	kv, err := s.Client()
+	if err != nil {
+		return err
+	}
+
+	return kv.Restore(r)
+}
+
+// SetLogLevel changes the plugin log level, restarted plugins keep it.
+func (s *Supervisor) SetLogLevel(level shared.LogLevel) error {
+	kv, err := s.Client()
+	if err != nil {
+		return err
+	}
+
+	err = kv.SetLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.logLevel = &level
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/tinybit/go-plugin-log-example/shared"
+)
+
+// envTestPlugin makes the test binary serve testKV as a plugin instead of
+// running the tests, so that the supervisor can launch it.
+const envTestPlugin = "KV_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(envTestPlugin) != "" {
+		plugin.Serve(&plugin.ServeConfig{
+			HandshakeConfig:  shared.PluginHandshakeConfig(),
+			VersionedPlugins: shared.PluginVersionedServerConfig(&testKV{}),
+			GRPCServer:       plugin.DefaultGRPCServer,
+			Logger:           hclog.NewNullLogger(),
+		})
+
+		os.Exit(0)
+	}
+
+	zlog.Logger = zerolog.Nop()
+
+	os.Exit(m.Run())
+}
+
+type testKV struct{}
+
+func (testKV) Ping() error                                          { return nil }
+func (testKV) Init(brokerID uint32, config map[string]string) error { return nil }
+func (testKV) SetLogger(log shared.LogHelper) error                 { return nil }
+func (testKV) Put(key string, value []byte) error                   { return nil }
+func (testKV) Get(key string) ([]byte, error)                       { return nil, shared.ErrNotFound }
+func (testKV) Delete(key string) error                              { return nil }
+
+type discardLogHelper struct{}
+
+func (discardLogHelper) Log(level int, msg string) error { return nil }
+
+// testPluginConfig returns a config launching the test binary as a plugin
+// once failures launches after the first one have failed with err.
+func testPluginConfig(failures int, err error) (func(spec *PluginSpec) (*plugin.ClientConfig, error), func() int) {
+	var mutex sync.Mutex
+	launches := 0
+
+	newConfig := func(spec *PluginSpec) (*plugin.ClientConfig, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		launches++
+		if launches > 1 && launches <= 1+failures {
+			return nil, err
+		}
+
+		cmd := exec.Command(spec.Path, "-test.run=^$")
+		cmd.Env = []string{envTestPlugin + "=1"}
+
+		return &plugin.ClientConfig{
+			HandshakeConfig:  shared.PluginHandshakeConfig(),
+			VersionedPlugins: shared.PluginVersionedClientConfig(),
+			Cmd:              cmd,
+			SkipHostEnv:      true,
+			AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+			Logger:           hclog.NewNullLogger(),
+		}, nil
+	}
+
+	count := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return launches
+	}
+
+	return newConfig, count
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	errLaunch := errors.New("plugin binary is missing")
+
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		maxRestarts  int
+		wantLaunches int
+		wantBackoff  time.Duration // sum of the waits before the restarts
+		wantErr      error
+	}{
+		{
+			name:         "restarts exited plugin",
+			wantLaunches: 2,
+			wantBackoff:  10 * time.Millisecond,
+		},
+		{
+			name:         "doubles backoff up to the max",
+			failures:     3,
+			err:          errLaunch,
+			wantLaunches: 5,
+			wantBackoff:  (10 + 20 + 40 + 40) * time.Millisecond,
+		},
+		{
+			name:         "restarts within max restarts",
+			failures:     1,
+			err:          errLaunch,
+			maxRestarts:  2,
+			wantLaunches: 3,
+			wantBackoff:  (10 + 20) * time.Millisecond,
+		},
+		{
+			name:         "gives up after max restarts",
+			failures:     3,
+			err:          errLaunch,
+			maxRestarts:  2,
+			wantLaunches: 3,
+			wantBackoff:  (10 + 20) * time.Millisecond,
+			wantErr:      errLaunch,
+		},
+		{
+			name:         "gives up on invalid config",
+			failures:     3,
+			err:          fmt.Errorf("%w: bad data dir", shared.ErrInvalidConfig),
+			wantLaunches: 2,
+			wantBackoff:  10 * time.Millisecond,
+			wantErr:      shared.ErrInvalidConfig,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			newConfig, launches := testPluginConfig(tc.failures, tc.err)
+
+			s := NewSupervisor(&PluginSpec{Path: os.Args[0]}, newConfig, SupervisorOptions{
+				MinBackoff:      10 * time.Millisecond,
+				MaxBackoff:      40 * time.Millisecond,
+				MaxRestarts:     tc.maxRestarts,
+				CheckInterval:   5 * time.Millisecond,
+				ShutdownTimeout: time.Second,
+				LogHelper:       discardLogHelper{},
+			})
+
+			err := s.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Stop()
+
+			first := s.PluginClient()
+
+			s.mutex.Lock()
+			restarted := s.restarted
+			s.mutex.Unlock()
+
+			start := time.Now()
+			first.Kill()
+
+			if !waitRestart(restarted, 5*time.Second) {
+				t.Fatal("plugin neither restarted nor given up")
+			}
+			elapsed := time.Since(start)
+
+			if elapsed < tc.wantBackoff {
+				t.Errorf("restarted after %v, want at least %v of backoff", elapsed, tc.wantBackoff)
+			}
+
+			if got := launches(); got != tc.wantLaunches {
+				t.Errorf("got %d launches, want %d", got, tc.wantLaunches)
+			}
+
+			kv, err := s.Client()
+			if tc.wantErr != nil {
+				if !errors.Is(err, ErrSupervisorFailed) || !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got error %v, want %v wrapping %v", err, ErrSupervisorFailed, tc.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.PluginClient() == first {
+				t.Fatal("plugin client was not replaced")
+			}
+
+			err = kv.Ping()
+			if err != nil {
+				t.Fatalf("restarted plugin: %v", err)
+			}
+		})
+	}
+}
+
+func TestSupervisorStop(t *testing.T) {
+	newConfig, _ := testPluginConfig(0, nil)
+
+	s := NewSupervisor(&PluginSpec{Path: os.Args[0]}, newConfig, DefaultSupervisorOptions())
+
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := s.PluginClient()
+	s.Stop()
+
+	if !client.Exited() {
+		t.Error("plugin process still running after Stop")
+	}
+
+	_, err = s.Client()
+	if !errors.Is(err, ErrSupervisorStopped) {
+		t.Errorf("got error %v, want %v", err, ErrSupervisorStopped)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// newSyslogWriter is not supported on this platform.
+func newSyslogWriter() (zerolog.LevelWriter, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
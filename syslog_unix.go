@@ -0,0 +1,20 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"log/syslog"
+
+	"github.com/rs/zerolog"
+)
+
+// newSyslogWriter connects to the local syslog daemon, messages get the
+// syslog priority of their log level.
+func newSyslogWriter() (zerolog.LevelWriter, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "kv")
+	if err != nil {
+		return nil, err
+	}
+
+	return zerolog.SyslogLevelWriter(w), nil
+}